@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// cookieURL returns the URL cookies are set for and read from, defaulting to
+// HTTP_ENDPOINT.
+func (a *apiFeature) cookieURL(target string) (*url.URL, error) {
+	target = a.getParsed(target)
+	if target == "" {
+		target = a.setting("HTTP_ENDPOINT")
+	}
+	if target == "" {
+		return nil, fmt.Errorf("No http endpoint defined. Please set HTTP_ENDPOINT env variable/memory or give a domain.")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	return url.Parse(target)
+}
+
+// responseCookie finds a cookie set by the last response, including its
+// redirects.
+func (a *apiFeature) responseCookie(name string) *http.Cookie {
+	var found *http.Cookie
+	for _, c := range a.lastCookies {
+		if c.Name == name {
+			found = c
+		}
+	}
+	return found
+}
+
+func (a *apiFeature) theCookieShouldBeSet(name string) (err error) {
+	defer a.withCurl(&err)
+	if a.responseCookie(name) == nil {
+		return fmt.Errorf("Expected cookie %s to be set by the last response", name)
+	}
+	return nil
+}
+
+func (a *apiFeature) theCookieShouldNotBeSet(name string) (err error) {
+	defer a.withCurl(&err)
+	if c := a.responseCookie(name); c != nil {
+		return fmt.Errorf("Expected cookie %s not to be set by the last response, got %s", name, c.String())
+	}
+	return nil
+}
+
+func (a *apiFeature) theCookieShouldBeSetWithAttributes(name, attributes string) (err error) {
+	defer a.withCurl(&err)
+	c := a.responseCookie(name)
+	if c == nil {
+		return fmt.Errorf("Expected cookie %s to be set by the last response", name)
+	}
+	for _, attr := range strings.Split(attributes, ",") {
+		attr = strings.TrimSpace(attr)
+		kv := strings.SplitN(attr, "=", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = a.getParsed(strings.TrimSpace(kv[1]))
+		}
+		var ok bool
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "httponly":
+			ok = c.HttpOnly
+		case "secure":
+			ok = c.Secure
+		case "samesite":
+			ok = cookieSameSite(c.SameSite) == strings.ToLower(value)
+		case "path":
+			ok = c.Path == value
+		case "domain":
+			ok = strings.TrimPrefix(c.Domain, ".") == strings.TrimPrefix(value, ".")
+		case "max-age":
+			ok = strconv.Itoa(c.MaxAge) == value
+		default:
+			return fmt.Errorf("Unsupported cookie attribute %s", attr)
+		}
+		if !ok {
+			return fmt.Errorf("Expected cookie %s to have attribute %s, got %s", name, attr, c.String())
+		}
+	}
+	return nil
+}
+
+func cookieSameSite(s http.SameSite) string {
+	switch s {
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteNoneMode:
+		return "none"
+	}
+	return ""
+}
+
+func (a *apiFeature) iSetCookieTo(name, value string) error {
+	return a.iSetCookieToFor(name, value, "")
+}
+func (a *apiFeature) iSetCookieToFor(name, value, target string) error {
+	u, err := a.cookieURL(target)
+	if err != nil {
+		return err
+	}
+	cookieJar.SetCookies(u, []*http.Cookie{{Name: name, Value: a.getParsed(value), Path: "/"}})
+	log.Trace().Str("name", name).Str("url", u.String()).Msg("Cookie set")
+	return nil
+}
+
+func (a *apiFeature) iDeleteCookie(name string) error {
+	return a.iDeleteCookieFor(name, "")
+}
+func (a *apiFeature) iDeleteCookieFor(name, target string) error {
+	u, err := a.cookieURL(target)
+	if err != nil {
+		return err
+	}
+	cookieJar.SetCookies(u, []*http.Cookie{{Name: name, Path: "/", MaxAge: -1}})
+	log.Trace().Str("name", name).Str("url", u.String()).Msg("Cookie deleted")
+	return nil
+}
+
+func (a *apiFeature) iClearCookies() error {
+	cookieJar, _ = cookiejar.New(nil)
+	return nil
+}
+
+func (a *apiFeature) iRememberCookieAs(name, key string) error {
+	value := ""
+	if c := a.responseCookie(name); c != nil {
+		value = c.Value
+	} else {
+		u, err := a.cookieURL("")
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, c := range cookieJar.Cookies(u) {
+			if c.Name == name {
+				value, found = c.Value, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("No cookie %s found", name)
+		}
+	}
+	a.memory[key] = value
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", value).Msg("Remembered")
+	return nil
+}
+
+func (a *apiFeature) iDumpCookies() error {
+	return a.iDumpCookiesFor("")
+}
+func (a *apiFeature) iDumpCookiesFor(target string) error {
+	for _, c := range a.lastCookies {
+		log.Info().Str("name", c.Name).Str("val", maskValue("set-cookie", c.Value)).Str("set-cookie", maskValue("set-cookie", c.String())).Msg("Response cookie dump")
+	}
+	u, err := a.cookieURL(target)
+	if err != nil {
+		return err
+	}
+	for _, c := range cookieJar.Cookies(u) {
+		log.Info().Str("name", c.Name).Str("val", maskValue("cookie", c.Value)).Str("url", u.String()).Msg("Cookie jar dump")
+	}
+	return nil
+}
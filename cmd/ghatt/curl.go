@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// curlCommand reproduces the last request, including the transport options
+// and the cookies sent from the jar, with secrets masked.
+func (a *apiFeature) curlCommand() (string, error) {
+	req := a.lastRequest
+	if req == nil {
+		return "", fmt.Errorf("No request sent")
+	}
+	args := []string{"curl", "-X", req.Method, shellQuote(maskSecrets(req.URL.String()))}
+	o := a.transportOptions()
+	if o.Insecure {
+		args = append(args, "-k")
+	}
+	for _, opt := range [][2]string{{"--cert", o.CertFile}, {"--key", o.KeyFile}, {"--cacert", o.CAFile}, {"--unix-socket", o.UnixSocket}} {
+		if opt[1] != "" {
+			args = append(args, opt[0], shellQuote(opt[1]))
+		}
+	}
+	switch o.Proxy {
+	case "":
+	case "direct":
+		args = append(args, "--noproxy", shellQuote("*"))
+	default:
+		args = append(args, "--proxy", shellQuote(o.Proxy))
+	}
+	for _, resolve := range splitList(o.Resolve) {
+		args = append(args, "--resolve", shellQuote(resolve))
+	}
+	if req.Host != "" && req.Host != req.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+req.Host))
+	}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			args = append(args, "-H", shellQuote(http.CanonicalHeaderKey(k)+": "+maskValue(k, v)))
+		}
+	}
+	if a.lastRequestBody != "" {
+		args = append(args, "--data-binary", shellQuote(maskSecrets(a.lastRequestBody)))
+	}
+	return strings.Join(args, " "), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// withCurl adds the curl command of the last request to the error of a
+// failed response assertion, as in defer a.withCurl(&err).
+func (a *apiFeature) withCurl(err *error) {
+	if *err == nil {
+		return
+	}
+	command, curlErr := a.curlCommand()
+	if curlErr != nil {
+		return
+	}
+	*err = fmt.Errorf("%w\nReproduce with: %s", *err, command)
+}
+
+func (a *apiFeature) iDumpRequestAsCurl() error {
+	command, err := a.curlCommand()
+	if err != nil {
+		return err
+	}
+	fmt.Println(command)
+	return nil
+}
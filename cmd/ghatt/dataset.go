@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cucumber/godog"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	datasetTag          = regexp.MustCompile(`@dataset\(([^)]+)\)`)
+	examplesFromFile    = regexp.MustCompile(`^(\s*)Examples from file "([^"]+)"\s*$`)
+	examplesLine        = regexp.MustCompile(`^\s*(Examples|Scenarios)\s*:`)
+	scenarioLine        = regexp.MustCompile(`^(\s*)(Scenario Outline|Scenario Template|Scenario|Example)\s*:`)
+	stepLine            = regexp.MustCompile(`^\s*(Given|When|Then|And|But|\*)\s`)
+	blockEndLine        = regexp.MustCompile(`^\s*(Scenario Outline|Scenario Template|Scenario|Example|Rule|Background|Feature)\s*:`)
+	datasetRowStepTitle = "Given I use dataset row:"
+	datasetRowColumn    = "dataset row" // examples column with the row as JSON, keeping value types
+	datasetDir          string          // temporary directory with expanded features
+)
+
+type dataset struct {
+	columns []string
+	rows    []map[string]string
+	json    []string
+}
+
+// expandDatasets rewrites feature files which reference external datasets
+// into a temporary directory, turning every dataset into an Examples table.
+// Paths of features without datasets are returned unchanged, expanded features
+// keep their absolute path below the temporary directory.
+func expandDatasets(paths []string) (result []string, err error) {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// let godog report it, it may be a path with a line filter
+			result = append(result, path)
+			continue
+		}
+		if !info.IsDir() {
+			expanded, err := expandFeatureFile(path)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expanded)
+			continue
+		}
+		var files []string
+		err = filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !f.IsDir() && strings.HasSuffix(p, ".feature") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			expanded, err := expandFeatureFile(file)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expanded)
+		}
+	}
+	return result, nil
+}
+
+func cleanupDatasets() {
+	if datasetDir != "" {
+		os.RemoveAll(datasetDir)
+	}
+}
+
+func expandFeatureFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !datasetTag.Match(content) && !strings.Contains(string(content), "Examples from file") {
+		return path, nil
+	}
+	expanded, err := expandFeature(string(content), filepath.Dir(path))
+	if err != nil {
+		return "", fmt.Errorf("Cannot expand datasets in %s: %s", path, err)
+	}
+	if datasetDir == "" {
+		if datasetDir, err = ioutil.TempDir("", "ghatt"); err != nil {
+			return "", err
+		}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(datasetDir, abs)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(target, []byte(expanded), 0644); err != nil {
+		return "", err
+	}
+	log.Debug().Str("feature", path).Str("expanded", target).Msg("Expanded datasets")
+	return target, nil
+}
+
+func expandFeature(content, dir string) (string, error) {
+	lines := strings.Split(content, "\n")
+	var out []string
+	i := 0
+	for i < len(lines) {
+		start, header, end := nextScenarioBlock(lines, i)
+		if header < 0 {
+			out = append(out, lines[i:]...)
+			break
+		}
+		out = append(out, lines[i:start]...)
+		block, err := expandScenario(lines[start:end], header-start, dir)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, block...)
+		i = end
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// nextScenarioBlock finds the next scenario at or after line i, returning the
+// first line of its tags, its header line and the line after its last step
+// or example.
+func nextScenarioBlock(lines []string, i int) (start, header, end int) {
+	header = -1
+	for j := i; j < len(lines); j++ {
+		if scenarioLine.MatchString(lines[j]) {
+			header = j
+			break
+		}
+	}
+	if header < 0 {
+		return i, -1, len(lines)
+	}
+	start = header
+	for start > i && isTagOrComment(lines[start-1]) {
+		start--
+	}
+	end = len(lines)
+	for j := header + 1; j < len(lines); j++ {
+		if blockEndLine.MatchString(lines[j]) {
+			end = j
+			for end > header+1 && isTagOrComment(lines[end-1]) {
+				end--
+			}
+			break
+		}
+	}
+	return start, header, end
+}
+
+func isTagOrComment(line string) bool {
+	t := strings.TrimSpace(line)
+	return strings.HasPrefix(t, "@") || strings.HasPrefix(t, "#")
+}
+
+// expandScenario replaces "@dataset(file)" tags and "Examples from file" lines
+// of a single scenario with Examples tables and injects a step which puts the
+// current row into memory and variables.
+func expandScenario(lines []string, header int, dir string) ([]string, error) {
+	var tagged *dataset
+	var columns []string
+	var out []string
+	for _, line := range lines[:header] {
+		if m := datasetTag.FindStringSubmatch(line); m != nil {
+			ds, err := loadDataset(filepath.Join(dir, strings.TrimSpace(m[1])))
+			if err != nil {
+				return nil, err
+			}
+			tagged = ds
+			columns = ds.columns
+			line = datasetTag.ReplaceAllString(line, "")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+		}
+		out = append(out, line)
+	}
+	m := scenarioLine.FindStringSubmatch(lines[header])
+	indent := m[1]
+	if tagged != nil {
+		out = append(out, scenarioLine.ReplaceAllString(lines[header], indent+"Scenario Outline:"))
+	} else {
+		out = append(out, lines[header])
+	}
+
+	var body []string
+	var tableHeaders, tableRows []int // Examples tables written in the feature
+	inExamples, tableHeader := false, false
+	for _, line := range lines[header+1:] {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case examplesLine.MatchString(line):
+			inExamples, tableHeader = true, true
+		case inExamples && strings.HasPrefix(trimmed, "|"):
+			if tableHeader {
+				tableHeaders = append(tableHeaders, len(body))
+				tableHeader = false
+			} else {
+				tableRows = append(tableRows, len(body))
+			}
+		case trimmed != "" && !strings.HasPrefix(trimmed, "#"):
+			inExamples = false
+		}
+		if m := examplesFromFile.FindStringSubmatch(line); m != nil {
+			ds, err := loadDataset(filepath.Join(dir, m[2]))
+			if err != nil {
+				return nil, err
+			}
+			if columns == nil {
+				columns = ds.columns
+			}
+			body = append(body, ds.examples(m[1])...)
+			continue
+		}
+		body = append(body, line)
+	}
+	if columns == nil {
+		return lines, nil
+	}
+	if err := addDatasetRowColumn(body, tableHeaders, tableRows, columns); err != nil {
+		return nil, fmt.Errorf("%s: %s", strings.TrimSpace(lines[header]), err)
+	}
+
+	injected := false
+	for _, line := range body {
+		if !injected && stepLine.MatchString(line) {
+			stepIndent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			out = append(out, stepIndent+datasetRowStepTitle, stepIndent+"  | name | value |")
+			for _, c := range append(columns, datasetRowColumn) {
+				out = append(out, stepIndent+"  | "+escapeCell(c)+" | <"+escapeCell(c)+"> |")
+			}
+			injected = true
+		}
+		out = append(out, line)
+	}
+	if tagged != nil {
+		trailing := 0
+		for trailing < len(out) && strings.TrimSpace(out[len(out)-1-trailing]) == "" {
+			trailing++
+		}
+		rest := append([]string{}, out[len(out)-trailing:]...)
+		out = append(out[:len(out)-trailing], tagged.examples(indent+"  ")...)
+		out = append(out, rest...)
+	}
+	return out, nil
+}
+
+// addDatasetRowColumn adds an empty dataset row to the Examples tables written
+// in the feature, as the injected step refers to the dataset row column. The
+// tables need all dataset columns for the same reason.
+func addDatasetRowColumn(body []string, headers, rows []int, columns []string) error {
+	for _, i := range rows {
+		body[i] = strings.TrimRight(body[i], " \t") + " {} |"
+	}
+	for _, i := range headers {
+		cells := map[string]bool{}
+		for _, c := range tableCells(body[i]) {
+			cells[c] = true
+		}
+		if cells[datasetRowColumn] {
+			return fmt.Errorf("Examples table has a %s column already", datasetRowColumn)
+		}
+		for _, c := range columns {
+			if !cells[c] {
+				return fmt.Errorf("Examples table has no column %s of the dataset", c)
+			}
+		}
+		body[i] = strings.TrimRight(body[i], " \t") + " " + datasetRowColumn + " |"
+	}
+	return nil
+}
+
+// tableCells splits a table row into its unescaped cell values.
+func tableCells(line string) []string {
+	var cells []string
+	var cell strings.Builder
+	escaped := false
+	for _, r := range strings.TrimSpace(line)[1:] {
+		switch {
+		case escaped:
+			if r == 'n' {
+				r = '\n'
+			}
+			cell.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteRune(r)
+		}
+	}
+	return cells
+}
+
+func (ds *dataset) examples(indent string) []string {
+	out := []string{"", indent + "Examples:"}
+	header := indent + "  |"
+	for _, c := range ds.columns {
+		header += " " + escapeCell(c) + " |"
+	}
+	out = append(out, header+" "+datasetRowColumn+" |")
+	for i, row := range ds.rows {
+		line := indent + "  |"
+		for _, c := range ds.columns {
+			line += " " + escapeCell(row[c]) + " |"
+		}
+		out = append(out, line+" "+escapeCell(ds.json[i])+" |")
+	}
+	return out
+}
+
+func escapeCell(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "|", `\|`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func loadDataset(path string) (*dataset, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("Dataset %s has no header row", path)
+		}
+		ds := &dataset{columns: records[0]}
+		for _, record := range records[1:] {
+			row := map[string]string{}
+			for i, c := range ds.columns {
+				if i < len(record) {
+					row[c] = record[i]
+				}
+			}
+			b, err := json.Marshal(row)
+			if err != nil {
+				return nil, err
+			}
+			ds.rows = append(ds.rows, row)
+			ds.json = append(ds.json, string(b))
+		}
+		return ds, nil
+	case ".json":
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(content, &rows); err != nil {
+			return nil, err
+		}
+		return newDataset(rows)
+	case ".yaml", ".yml":
+		var rows []map[string]interface{}
+		if err := yaml.Unmarshal(content, &rows); err != nil {
+			return nil, err
+		}
+		return newDataset(rows)
+	default:
+		return nil, fmt.Errorf("Unsupported dataset format %s", path)
+	}
+}
+
+func newDataset(rows []map[string]interface{}) (*dataset, error) {
+	ds := &dataset{}
+	seen := map[string]bool{}
+	for _, r := range rows {
+		keys := []string{}
+		for k := range r {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		row := map[string]string{}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				ds.columns = append(ds.columns, k)
+			}
+			switch v := r[k].(type) {
+			case string:
+				row[k] = v
+			case nil:
+				row[k] = ""
+			case float64:
+				row[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			case map[string]interface{}, []interface{}:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				row[k] = string(b)
+			default:
+				row[k] = fmt.Sprint(v)
+			}
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		ds.rows = append(ds.rows, row)
+		ds.json = append(ds.json, string(b))
+	}
+	return ds, nil
+}
+
+// iUseDatasetRow is injected into expanded scenarios and populates memory and
+// variables with the current dataset row. Variables get the values of the
+// JSON encoded row, so numbers, booleans and objects keep their types.
+func (a *apiFeature) iUseDatasetRow(table *godog.Table) error {
+	for _, row := range table.Rows[1:] {
+		key, value := row.Cells[0].Value, row.Cells[1].Value
+		if key == datasetRowColumn {
+			v, err := parseTypedValue("json", value)
+			if err != nil {
+				return fmt.Errorf("Cannot parse dataset row: %s", err)
+			}
+			values, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("Dataset row is not an object: %s", value)
+			}
+			for k, val := range values {
+				a.variables[k] = val
+			}
+			continue
+		}
+		a.memory[key] = value
+		delete(a.memoryScopes, key)
+		a.variables[key] = value
+		log.Trace().Str("key", key).Str("val", value).Msg("Dataset row")
+	}
+	return nil
+}
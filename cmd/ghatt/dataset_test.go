@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cucumber/godog"
+	"github.com/cucumber/messages-go/v16"
+)
+
+func TestExpandFeatureFileTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghatt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		cleanupDatasets()
+		datasetDir = ""
+	}()
+	feature := "Feature: f\n  @dataset(rows.csv)\n  Scenario: s\n    Given I dump memory\n"
+	for _, sub := range []string{"a", filepath.Join("b", "a")} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, sub, "rows.csv"), []byte("code\n"+sub+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, sub, "x.feature"), []byte(feature), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, err := expandFeatureFile(filepath.Join(dir, "b", "..", "a", "x.feature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := expandFeatureFile(filepath.Join(dir, "b", "a", "x.feature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("features expanded to the same file %s", first)
+	}
+	again, err := expandFeatureFile(filepath.Join(dir, "a", "x.feature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("got %s for the same feature, want %s", again, first)
+	}
+}
+
+func TestNewDatasetKeepsTypes(t *testing.T) {
+	ds, err := newDataset([]map[string]interface{}{
+		{"code": "DE", "population": float64(83000000), "active": true, "meta": map[string]interface{}{"x": float64(1)}, "note": nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRow := map[string]string{"code": "DE", "population": "83000000", "active": "true", "meta": `{"x":1}`, "note": ""}
+	if !reflect.DeepEqual(ds.rows[0], wantRow) {
+		t.Errorf("got row %v, want %v", ds.rows[0], wantRow)
+	}
+
+	a := &apiFeature{memory: map[string]interface{}{}, memoryScopes: map[string]string{}, variables: map[string]interface{}{}}
+	table := &godog.Table{Rows: []*messages.PickleTableRow{
+		datasetTableRow("name", "value"),
+		datasetTableRow("population", wantRow["population"]),
+		datasetTableRow(datasetRowColumn, ds.json[0]),
+	}}
+	if err := a.iUseDatasetRow(table); err != nil {
+		t.Fatal(err)
+	}
+	if a.memory["population"] != "83000000" {
+		t.Errorf("got memory %v, want the string 83000000", a.memory["population"])
+	}
+	wantVariables := map[string]interface{}{"code": "DE", "population": float64(83000000), "active": true, "meta": map[string]interface{}{"x": float64(1)}, "note": nil}
+	if !reflect.DeepEqual(a.variables, wantVariables) {
+		t.Errorf("got variables %v, want %v", a.variables, wantVariables)
+	}
+}
+
+func TestExpandFeatureWithInlineExamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghatt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "rows.csv"), []byte("code,name\nDE,Germany\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outline := func(table string) string {
+		return "Feature: f\n" +
+			"  Scenario Outline: s\n" +
+			"    Given I dump memory\n" +
+			"    Examples:\n" +
+			"      # inline\n" +
+			table +
+			"\n" +
+			"    Examples from file \"rows.csv\"\n"
+	}
+	tests := []struct {
+		name    string
+		table   string
+		want    []string
+		wantErr bool
+	}{
+		{"adds empty dataset rows", "      | name   | code |\n      | France | FR   |\n      | a \\| b  | X    |\n", []string{
+			"      | name   | code | dataset row |",
+			"      | France | FR   | {} |",
+			"      | a \\| b  | X    | {} |",
+			"      | code | name | dataset row |",
+			`      | DE | Germany | {"code":"DE","name":"Germany"} |`,
+		}, false},
+		{"missing dataset column", "      | code |\n      | FR   |\n", nil, true},
+		{"dataset row column", "      | code | name | dataset row |\n      | FR | France | {} |\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded, err := expandFeature(outline(tt.table), dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			var rows []string
+			examples := strings.Index(expanded, "Examples:")
+			for _, line := range strings.Split(expanded[examples+1:], "\n") {
+				if strings.HasPrefix(line, "      |") {
+					rows = append(rows, line)
+				}
+			}
+			if !tt.wantErr && !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("got rows\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func datasetTableRow(cells ...string) *messages.PickleTableRow {
+	row := &messages.PickleTableRow{}
+	for _, c := range cells {
+		row.Cells = append(row.Cells, &messages.PickleTableCell{Value: c})
+	}
+	return row
+}
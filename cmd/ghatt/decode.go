@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// response formats decodable into values for jq and jsonpath steps
+const (
+	formatJSON          = "json"
+	formatYAML          = "yaml"
+	formatCSV           = "csv"
+	formatCSVWithHeader = "csv with header"
+	formatNDJSON        = "ndjson"
+)
+
+var contentTypeFormats = map[string]string{
+	"application/yaml":     formatYAML,
+	"application/x-yaml":   formatYAML,
+	"text/yaml":            formatYAML,
+	"text/x-yaml":          formatYAML,
+	"text/csv":             formatCSVWithHeader,
+	"application/csv":      formatCSVWithHeader,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+	"application/jsonl":    formatNDJSON,
+	"application/x-jsonl":  formatNDJSON,
+}
+
+// responseFormat is the format set by an explicit step, otherwise detected
+// from the Content-Type header, defaulting to JSON.
+func (a *apiFeature) responseFormat() string {
+	if a.lastFormat != "" {
+		return a.lastFormat
+	}
+	for k, v := range a.lastHeaders {
+		if strings.EqualFold(k, "Content-Type") {
+			mediaType, _, err := mime.ParseMediaType(v)
+			if err != nil {
+				break
+			}
+			if format, ok := contentTypeFormats[strings.ToLower(mediaType)]; ok {
+				return format
+			}
+			if strings.HasSuffix(mediaType, "+yaml") {
+				return formatYAML
+			}
+		}
+	}
+	return formatJSON
+}
+
+// unmarshalResponse decodes the last response body into v like
+// json.Unmarshal, converting YAML, CSV and NDJSON bodies to JSON first.
+func (a *apiFeature) unmarshalResponse(v interface{}) error {
+	format := a.responseFormat()
+	if format == formatJSON {
+		return json.Unmarshal(a.lastBody, v)
+	}
+	data, err := decodeBody(a.lastBody, format)
+	if err != nil {
+		return fmt.Errorf("Cannot parse response as %s: %s", format, err)
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Cannot convert %s response to json: %s", format, err)
+	}
+	return json.Unmarshal(content, v)
+}
+
+func decodeBody(body []byte, format string) (interface{}, error) {
+	switch format {
+	case formatYAML:
+		var v interface{}
+		err := yaml.Unmarshal(body, &v)
+		return v, err
+	case formatCSV, formatCSVWithHeader:
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if format == formatCSV {
+			return records, nil
+		}
+		rows := []map[string]string{}
+		for i, record := range records {
+			if i == 0 {
+				continue
+			}
+			row := map[string]string{}
+			for j, column := range records[0] {
+				if j < len(record) {
+					row[column] = record[j]
+				}
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	case formatNDJSON:
+		lines := []interface{}{}
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var v interface{}
+			if err := json.Unmarshal(line, &v); err != nil {
+				return nil, err
+			}
+			lines = append(lines, v)
+		}
+		return lines, scanner.Err()
+	}
+	return nil, fmt.Errorf("Unsupported response format %s", format)
+}
+
+func (a *apiFeature) theResponseIsParsedAs(format string) error {
+	a.lastFormat = format
+	return nil
+}
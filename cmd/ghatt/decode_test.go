@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		want    interface{}
+		wantErr bool
+	}{
+		{"yaml", formatYAML, "a: 1\nb: [x, y]\n", map[string]interface{}{"a": 1, "b": []interface{}{"x", "y"}}, false},
+		{"yaml invalid", formatYAML, "a: [", nil, true},
+		{"csv", formatCSV, "id,name\n1,a\n", [][]string{{"id", "name"}, {"1", "a"}}, false},
+		{"csv with header", formatCSVWithHeader, "id,name\n1,a\n2,b\n",
+			[]map[string]string{{"id": "1", "name": "a"}, {"id": "2", "name": "b"}}, false},
+		{"csv header only", formatCSVWithHeader, "id,name\n", []map[string]string{}, false},
+		{"csv empty", formatCSVWithHeader, "", []map[string]string{}, false},
+		{"csv quoted", formatCSVWithHeader, "id,note\n1,\"a, \"\"b\"\"\"\n", []map[string]string{{"id": "1", "note": `a, "b"`}}, false},
+		{"csv uneven rows", formatCSVWithHeader, "id,name\n1\n", nil, true},
+		{"ndjson", formatNDJSON, "{\"a\":1}\n\n  [2]  \n\"x\"", []interface{}{map[string]interface{}{"a": float64(1)}, []interface{}{float64(2)}, "x"}, false},
+		{"ndjson crlf", formatNDJSON, "{\"a\":1}\r\n{\"a\":2}\r\n", []interface{}{map[string]interface{}{"a": float64(1)}, map[string]interface{}{"a": float64(2)}}, false},
+		{"ndjson empty", formatNDJSON, "", []interface{}{}, false},
+		{"ndjson invalid line", formatNDJSON, "{\"a\":1}\n{", nil, true},
+		{"unsupported", "xml", "<a/>", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBody([]byte(tt.body), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
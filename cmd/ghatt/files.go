@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+func (a *apiFeature) iSendrequestToWithBodyFromFile(method, path, file string) error {
+	content, err := ioutil.ReadFile(a.getParsed(file))
+	if err != nil {
+		return err
+	}
+	return a.sendCheckedRequest(method, path, string(content), false)
+}
+
+func (a *apiFeature) iSendrequestToWithTemplatedBodyFromFile(method, path, file string) error {
+	content, err := ioutil.ReadFile(a.getParsed(file))
+	if err != nil {
+		return err
+	}
+	return a.sendCheckedRequest(method, path, string(content), true)
+}
+
+func (a *apiFeature) iSaveTheResponseBodyTo(file string) error {
+	file = a.getParsed(file)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	log.Trace().Str("file", file).Int("size", len(a.lastBody)).Msg("Saving response body")
+	return ioutil.WriteFile(file, a.lastBody, 0644)
+}
+
+func (a *apiFeature) theResponseBodySizeShouldBe(size int) (err error) {
+	defer a.withCurl(&err)
+	if len(a.lastBody) != size {
+		return fmt.Errorf("expected response body size to be: %d bytes, but actual is: %d bytes", size, len(a.lastBody))
+	}
+	return nil
+}
+
+func (a *apiFeature) theResponseBodySHA256ShouldBe(sum string) (err error) {
+	defer a.withCurl(&err)
+	sum = strings.ToLower(a.getParsed(sum))
+	if actual := sha256Hex(a.lastBody); actual != sum {
+		return fmt.Errorf("expected response body SHA-256 to be: %s, but actual is: %s", sum, actual)
+	}
+	return nil
+}
+
+// theResponseBodyShouldBeSniffedAs compares media types detected from the
+// content, ignoring the Content-Type header and parameters like charset.
+func (a *apiFeature) theResponseBodyShouldBeSniffedAs(mediaType string) (err error) {
+	defer a.withCurl(&err)
+	sniffed := http.DetectContentType(a.lastBody)
+	actual, _, err := mime.ParseMediaType(sniffed)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, mediaType) && !strings.EqualFold(sniffed, mediaType) {
+		return fmt.Errorf("expected response body to be sniffed as: %s, but actual is: %s", mediaType, sniffed)
+	}
+	return nil
+}
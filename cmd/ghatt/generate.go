@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var (
+	camelCaseBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	nonWordChars      = regexp.MustCompile(`[^A-Za-z0-9]+`)
+)
+
+// runGenerate implements "ghatt generate graphql --schema schema.graphql --out features/".
+func runGenerate(args []string) int {
+	if len(args) == 0 || args[0] != "graphql" {
+		fmt.Fprintln(os.Stderr, "usage: ghatt generate graphql --schema schema.graphql [--out features] [--depth 2]")
+		return 2
+	}
+	flags := flag.NewFlagSet("generate graphql", flag.ContinueOnError)
+	schemaFiles := flags.String("schema", "", "comma separated GraphQL schema files")
+	out := flags.String("out", "features", "output directory")
+	depth := flags.Int("depth", 2, "depth of nested object selections")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *schemaFiles == "" {
+		fmt.Fprintln(os.Stderr, "--schema is required")
+		return 2
+	}
+	if *depth < 1 {
+		*depth = 1
+	}
+	if err := generateGraphQL(splitList(*schemaFiles), *out, *depth); err != nil {
+		log.Error().Err(err).Msg("Cannot generate features")
+		return 1
+	}
+	return 0
+}
+
+func generateGraphQL(schemaFiles []string, out string, depth int) error {
+	var sources []*ast.Source
+	for _, file := range schemaFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, &ast.Source{Name: file, Input: string(content)})
+	}
+	schema, gqlErr := gqlparser.LoadSchema(sources...)
+	if gqlErr != nil {
+		return gqlErr
+	}
+	dir := filepath.Join(out, "graphql")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	g := &graphqlGenerator{schema: schema, depth: depth}
+	for _, root := range []struct {
+		operation string
+		feature   string
+		def       *ast.Definition
+	}{{"query", "queries.feature", schema.Query}, {"mutation", "mutations.feature", schema.Mutation}} {
+		if root.def == nil {
+			continue
+		}
+		var feature strings.Builder
+		fmt.Fprintf(&feature, "Feature: GraphQL %s operations\n", root.operation)
+		fmt.Fprintf(&feature, "  Generated from %s, replace TODO values and extend the assertions.\n\n", strings.Join(schemaFiles, ", "))
+		fmt.Fprintf(&feature, "  Background:\n    Given I load variables from directory %q\n", filepath.ToSlash(dir))
+		count := 0
+		for _, field := range root.def.Fields {
+			if strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+			key := strings.ToUpper(root.operation + "_" + snakeCase(field.Name))
+			file := filepath.Join(dir, key+".graphql")
+			if err := ioutil.WriteFile(file, []byte(g.operation(root.operation, field)), 0644); err != nil {
+				return err
+			}
+			feature.WriteString("\n" + g.scenario(root.operation, key, field))
+			count++
+		}
+		featureFile := filepath.Join(out, root.feature)
+		if err := ioutil.WriteFile(featureFile, []byte(feature.String()), 0644); err != nil {
+			return err
+		}
+		log.Info().Str("file", featureFile).Int("operations", count).Msg("Generated")
+	}
+	return nil
+}
+
+type graphqlGenerator struct {
+	schema *ast.Schema
+	depth  int
+}
+
+// operation renders a named operation with a variable for every argument.
+func (g *graphqlGenerator) operation(operation string, field *ast.FieldDefinition) string {
+	var b strings.Builder
+	b.WriteString(operation + " " + strings.Title(field.Name))
+	var vars, args []string
+	for _, arg := range field.Arguments {
+		vars = append(vars, "$"+arg.Name+": "+arg.Type.String())
+		args = append(args, arg.Name+": $"+arg.Name)
+	}
+	if len(vars) > 0 {
+		b.WriteString("(" + strings.Join(vars, ", ") + ")")
+	}
+	b.WriteString(" {\n  " + field.Name)
+	if len(args) > 0 {
+		b.WriteString("(" + strings.Join(args, ", ") + ")")
+	}
+	selection := g.selection(field.Type.Name(), 1, g.depth)
+	if def := g.schema.Types[field.Type.Name()]; selection == "" && def != nil && !def.IsLeafType() {
+		selection = " {\n    __typename\n  }"
+	}
+	b.WriteString(selection + "\n}\n")
+	return b.String()
+}
+
+// selection renders the selection set of a type: scalar fields, and object
+// fields without required arguments down to depth levels.
+func (g *graphqlGenerator) selection(typeName string, indent, depth int) string {
+	def := g.schema.Types[typeName]
+	if def == nil || def.IsLeafType() {
+		return ""
+	}
+	pad := strings.Repeat("  ", indent+1)
+	var lines []string
+	if def.Kind == ast.Union || def.Kind == ast.Interface {
+		lines = append(lines, pad+"__typename")
+	}
+	for _, field := range def.Fields {
+		if strings.HasPrefix(field.Name, "__") || hasRequiredArguments(field) {
+			continue
+		}
+		fieldDef := g.schema.Types[field.Type.Name()]
+		if fieldDef == nil || fieldDef.IsLeafType() {
+			lines = append(lines, pad+field.Name)
+		} else if depth > 1 {
+			if sub := g.selection(field.Type.Name(), indent+1, depth-1); sub != "" {
+				lines = append(lines, pad+field.Name+sub)
+			}
+		}
+	}
+	if def.Kind == ast.Union && depth > 1 {
+		for _, member := range def.Types {
+			if sub := g.selection(member, indent+1, depth-1); sub != "" {
+				lines = append(lines, pad+"... on "+member+sub)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return " {\n" + strings.Join(lines, "\n") + "\n" + strings.Repeat("  ", indent) + "}"
+}
+
+func hasRequiredArguments(field *ast.FieldDefinition) bool {
+	for _, arg := range field.Arguments {
+		if arg.Type.NonNull && arg.DefaultValue == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// scenario sets placeholder values for required arguments, executes the
+// operation and checks there are no errors.
+func (g *graphqlGenerator) scenario(operation, key string, field *ast.FieldDefinition) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  Scenario: %s %s\n", strings.Title(operation), field.Name)
+	step := "Given"
+	for _, arg := range field.Arguments {
+		if !arg.Type.NonNull || arg.DefaultValue != nil {
+			continue
+		}
+		switch value := g.placeholder(arg.Type, 3).(type) {
+		case string:
+			fmt.Fprintf(&b, "    %s I set variable %q as %q\n", step, arg.Name, value)
+		case int:
+			fmt.Fprintf(&b, "    %s I set variable %q as number \"%d\"\n", step, arg.Name, value)
+		case float64:
+			fmt.Fprintf(&b, "    %s I set variable %q as float \"%g\"\n", step, arg.Name, value)
+		case bool:
+			fmt.Fprintf(&b, "    %s I set variable %q as boolean \"%t\"\n", step, arg.Name, value)
+		default:
+			content, _ := json.MarshalIndent(value, "      ", "  ")
+			fmt.Fprintf(&b, "    %s I set variable %q as json:\n      \"\"\"\n      %s\n      \"\"\"\n", step, arg.Name, content)
+		}
+		step = "And"
+	}
+	fmt.Fprintf(&b, "    When I execute query %q\n", key)
+	b.WriteString("    Then the response code should be 200\n")
+	b.WriteString("    And the response errors jq \"length\" should match number \"0\"\n")
+	return b.String()
+}
+
+// placeholder returns a value of the type, with required input object
+// fields filled in down to depth levels.
+func (g *graphqlGenerator) placeholder(t *ast.Type, depth int) interface{} {
+	if t.Elem != nil {
+		return []interface{}{g.placeholder(t.Elem, depth)}
+	}
+	switch t.NamedType {
+	case "Int":
+		return 0
+	case "Float":
+		return 0.0
+	case "Boolean":
+		return false
+	}
+	def := g.schema.Types[t.NamedType]
+	switch {
+	case def == nil:
+		return "TODO"
+	case def.Kind == ast.Enum && len(def.EnumValues) > 0:
+		return def.EnumValues[0].Name
+	case def.Kind == ast.InputObject:
+		obj := map[string]interface{}{}
+		if depth > 0 {
+			for _, field := range def.Fields {
+				if field.Type.NonNull && field.DefaultValue == nil {
+					obj[field.Name] = g.placeholder(field.Type, depth-1)
+				}
+			}
+		}
+		return obj
+	}
+	return "TODO"
+}
+
+func snakeCase(name string) string {
+	name = camelCaseBoundary.ReplaceAllString(name, "${1}_${2}")
+	return strings.Trim(nonWordChars.ReplaceAllString(name, "_"), "_")
+}
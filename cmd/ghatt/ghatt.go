@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -12,6 +14,7 @@ import (
 	"net/http/cookiejar"
 	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
@@ -29,29 +32,53 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/ksuid"
 	"github.com/tidwall/pretty"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	opt = godog.Options{
-		Output: colors.Colored(os.Stdout),
+		Output: colors.Colored(secretWriter{os.Stdout}),
 		Format: "progress", // or "pretty"
 	}
 	cookieJar     *cookiejar.Jar
 	defaultMemory map[string]string
 	seeded        string = "HTTP_ENDPOINT,GRAPHQL_ENDPOINT,RESET_ENDPOINT,RESET_METHOD,RESET_BODY"
 	funcMap       template.FuncMap
+	resetTag      = regexp.MustCompile(`^@reset\((.+)\)$`)
+	// commands run instead of the suite, like "ghatt generate graphql" or "ghatt import postman"
+	commands = map[string]func(args []string) int{
+		"generate": runGenerate,
+		"import":   runImport,
+	}
 )
 
 type apiFeature struct {
-	URL         string
-	lastCode    int
-	lastStatus  string
-	lastBody    []byte
-	lastErrors  []byte
-	lastHeaders map[string]string
-	memory      map[string]interface{}
-	variables   map[string]interface{}
-	headers     map[string]string
+	URL             string
+	feature         string
+	lastCode        int
+	lastStatus      string
+	lastBody        []byte
+	lastErrors      []byte
+	lastHeaders     map[string]string
+	lastTLS         *tls.ConnectionState
+	lastFormat      string
+	memory          map[string]interface{}
+	memoryScopes    map[string]string
+	variables       map[string]interface{}
+	headers         map[string]string
+	oauth2          *oauth2Config
+	signer          requestSigner
+	maxRedirects    int
+	redirects       []redirectHop
+	lastCookies     []*http.Cookie
+	xmlNamespaces   map[string]string
+	eventStream     *eventStream
+	websocket       *websocketConn
+	grpcStatus      *status.Status
+	grpcTrailers    metadata.MD
+	lastRequest     *http.Request
+	lastRequestBody string
 }
 
 func ExampleULID() string {
@@ -64,58 +91,192 @@ func ExampleKSUID() string {
 	return ksuid.New().String()
 }
 
-func (a *apiFeature) resetDatabase(*godog.Scenario) bool {
-	if endpoint, ok := a.memory["RESET_ENDPOINT"]; ok {
-		if endpoint != "" {
-			url := endpoint.(string)
-			log.Trace().Str("url", url).Msg("Reset DB")
-			method := "GET"
-			if m, ok := a.memory["RESET_METHOD"]; ok {
-				method = m.(string)
-			}
-			var err error
+// resetDatabase calls the reset endpoint before a scenario. Scenarios tagged
+// @no-reset are not reset, @reset(name) tags select resets configured with
+// RESET_<NAME>_* keys instead of the default RESET_* ones. The returned
+// error fails the scenario.
+func (a *apiFeature) resetDatabase(sc *godog.Scenario) (bool, error) {
+	if err := suiteStopped(); err != nil {
+		return false, err
+	}
+	var names []string
+	for _, tag := range sc.Tags {
+		if tag.Name == "@no-reset" {
+			log.Trace().Msg("Skipping reset - scenario tagged @no-reset")
+			return false, nil
+		}
+		if m := resetTag.FindStringSubmatch(tag.Name); m != nil {
+			names = append(names, m[1])
+		}
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	reset := false
+	for _, name := range names {
+		done, err := a.resetWith(name)
+		reset = reset || done
+		if err != nil {
+			return reset, a.failReset(err)
+		}
+	}
+	return reset, nil
+}
 
-			if body, ok := a.memory["RESET_BODY"]; ok {
-				err = a.sendrequestTo(method, url, body.(string))
-			} else {
-				err = a.sendrequestTo(method, url, "")
-			}
-			if err != nil {
-				fmt.Errorf("GOT ERROR: %s\n", err)
-			}
-			if a.lastCode != 200 {
-				fmt.Errorf("AFTER DB RESET: code=%d status=%s body=%s\n", a.lastCode, a.lastStatus, a.lastBody)
+func (a *apiFeature) resetWith(name string) (bool, error) {
+	endpoint, _ := a.resetSetting(name, "ENDPOINT")
+	if endpoint == "" {
+		if name != "" {
+			return false, fmt.Errorf("No reset endpoint defined for %s. Please set RESET_%s_ENDPOINT or RESET_ENDPOINT env variable/memory.", name, strings.ToUpper(name))
+		}
+		log.Trace().Msg("Skipping reset - no RESET_ENDPOINT defined")
+		return false, nil
+	}
+	log.Trace().Str("url", endpoint).Str("name", name).Msg("Reset DB")
+	method, ok := a.resetSetting(name, "METHOD")
+	if !ok {
+		method = "GET"
+	}
+	a.headers = map[string]string{}
+	if h, ok := a.resetSetting(name, "HEADERS"); ok {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(h), &headers); err != nil {
+			return false, fmt.Errorf("Cannot parse RESET_HEADERS as json object: %s", err)
+		}
+		for k, v := range headers {
+			a.headers[k] = a.getParsed(v)
+		}
+	}
+	body, _ := a.resetSetting(name, "BODY")
+	query, isQuery := a.resetSetting(name, "QUERY")
+	if isQuery {
+		var variables interface{}
+		if v, ok := a.resetSetting(name, "VARIABLES"); ok {
+			if err := json.Unmarshal([]byte(a.getParsed(v)), &variables); err != nil {
+				return false, fmt.Errorf("Cannot parse RESET_VARIABLES as json: %s", err)
 			}
+		}
+		content, err := json.Marshal(struct {
+			Query     string      `json:"query"`
+			Variables interface{} `json:"variables"`
+		}{Query: a.getParsed(query), Variables: variables})
+		if err != nil {
+			return false, err
+		}
+		body = string(content)
+		if _, ok := a.resetSetting(name, "METHOD"); !ok {
+			method = "POST"
+		}
+		a.headers["Content-Type"] = "application/json"
+	}
+
+	if err := a.sendrequestTo(method, endpoint, body); err != nil {
+		return true, fmt.Errorf("Reset request failed: %s", err)
+	}
+	expected, ok := a.resetSetting(name, "STATUS")
+	if !ok {
+		expected = "200"
+	}
+	if !containsCode(expected, a.lastCode) {
+		return true, fmt.Errorf("Reset failed: expected code %s, got code=%d status=%s body=%s", expected, a.lastCode, a.lastStatus, a.lastBody)
+	}
+	if isQuery {
+		var resp struct {
+			Errors []struct {
+				Message string `json:"message,omitempty"`
+			} `json:"errors,omitempty"`
+		}
+		if err := json.Unmarshal(a.lastBody, &resp); err != nil {
+			return true, fmt.Errorf("Reset failed: cannot parse response: %s", err)
+		}
+		if len(resp.Errors) > 0 {
+			return true, fmt.Errorf("Reset failed: %s", resp.Errors[0].Message)
+		}
+	}
+	return true, nil
+}
+
+// resetSetting looks up RESET_<NAME>_<KEY> for named resets, falling back to
+// RESET_<KEY>.
+func (a *apiFeature) resetSetting(name, key string) (string, bool) {
+	if name != "" {
+		if v, ok := a.memory["RESET_"+strings.ToUpper(name)+"_"+key]; ok {
+			return fmt.Sprintf("%v", v), true
+		}
+	}
+	if v, ok := a.memory["RESET_"+key]; ok {
+		return fmt.Sprintf("%v", v), true
+	}
+	return "", false
+}
+
+func containsCode(codes string, code int) bool {
+	for _, c := range strings.Split(codes, ",") {
+		if strings.TrimSpace(c) == strconv.Itoa(code) {
 			return true
-		} else {
-			log.Trace().Msg("Skipping reset - empty RESET_ENDPOINT defined")
-			return false
 		}
-	} else {
-		log.Trace().Msg("Skipping reset - no RESET_ENDPOINT defined")
-		return false
 	}
+	return false
 }
 
-func (a *apiFeature) resetResponse(sc *godog.Scenario) {
+// failReset handles a failed reset according to RESET_ON_FAILURE: "fail"
+// (default) fails the scenario, "abort" stops the suite and "ignore" only
+// logs the error. It returns the error failing the scenario, if any.
+func (a *apiFeature) failReset(err error) error {
+	mode, _ := a.resetSetting("", "ON_FAILURE")
+	switch mode {
+	case "ignore":
+		log.Warn().Err(err).Msg("Ignoring failed reset")
+		return nil
+	case "abort":
+		log.Error().Err(err).Msg("Aborting suite after failed reset")
+		stopSuite(fmt.Errorf("Suite aborted after failed reset: %s", err), "GHATT RESET FAIL")
+	case "fail", "":
+		log.Error().Err(err).Msg("Reset failed")
+	default:
+		log.Warn().Str("RESET_ON_FAILURE", mode).Msg("Unsupported mode")
+	}
+	return err
+}
+
+// resetResponse runs before every scenario, a failed reset fails the
+// scenario on its first step.
+func (a *apiFeature) resetResponse(ctx context.Context, sc *godog.Scenario) (context.Context, error) {
 	log.Trace().Msg("Reset reponse")
-	a.memory = map[string]interface{}{}
-	for k, v := range defaultMemory {
-		a.memory[k] = v
-	}
-	if a.resetDatabase(sc) {
-		a.memory = map[string]interface{}{}
-		for k, v := range defaultMemory {
-			a.memory[k] = v
-		}
+	a.feature = sc.Uri
+	a.seedMemory()
+	a.oauth2 = nil
+	a.signer = nil
+	a.maxRedirects = -1
+	reset, err := a.resetDatabase(sc)
+	if reset {
+		a.seedMemory()
 	}
 	a.lastBody = []byte("")
 	a.lastCode = 0
 	a.lastStatus = ""
 	a.lastHeaders = map[string]string{}
 	a.lastErrors = []byte("")
+	a.lastTLS = nil
+	a.lastFormat = ""
+	a.redirects = nil
+	a.lastCookies = nil
+	a.grpcStatus = nil
+	a.grpcTrailers = nil
+	a.lastRequest = nil
+	a.lastRequestBody = ""
+	a.xmlNamespaces = map[string]string{}
 	a.variables = map[string]interface{}{}
 	a.headers = map[string]string{}
+	return ctx, err
+}
+
+// closeScenario runs after every scenario and closes the connections it
+// left open, as each scenario gets its own apiFeature.
+func (a *apiFeature) closeScenario(ctx context.Context, sc *godog.Scenario, err error) (context.Context, error) {
+	a.closeEventStream()
+	a.closeWebsocket()
+	return ctx, nil
 }
 
 func (a *apiFeature) getParsed(source string) string {
@@ -132,25 +293,21 @@ func (a *apiFeature) getParsed(source string) string {
 }
 
 func (a *apiFeature) iSendrequestTo(method, endpoint string) (err error) {
-	return a.sendrequestTo(method, endpoint, "")
+	return a.sendCheckedRequest(method, endpoint, "", true)
 }
 func (a *apiFeature) iSendrequestToWithData(method, path string, body *godog.DocString) (err error) {
-	return a.sendrequestTo(method, path, body.Content)
+	return a.sendCheckedRequest(method, path, body.Content, true)
 }
 func (a *apiFeature) sendrequestTo(method, path string, body string) (err error) {
-	var url string
-	path = a.getParsed(path)
-	if path[0:4] == "http" {
-		url = path
-	} else {
-		if _, ok := a.memory["HTTP_ENDPOINT"]; ok == false {
-			return fmt.Errorf("No http endpoint defined. Please set HTTP_ENDPOINT env variable/memory.")
-		}
-		endpoint := a.memory["HTTP_ENDPOINT"].(string)
-		if endpoint == "" {
-			return fmt.Errorf("No http endpoint defined. Please set HTTP_ENDPOINT env variable/memory.")
-		}
-		url = endpoint + path
+	return a.sendRequest(method, path, body, true)
+}
+
+// sendRequest sends the body as is unless templated is set, so binary
+// content from files is not mangled by the template engine.
+func (a *apiFeature) sendRequest(method, path string, body string, templated bool) (err error) {
+	url, err := a.requestURL(path)
+	if err != nil {
+		return err
 	}
 
 	// handle panic
@@ -162,21 +319,23 @@ func (a *apiFeature) sendrequestTo(method, path string, body string) (err error)
 			err = t
 		}
 	}()
-	body = a.getParsed(body)
+	if templated {
+		body = a.getParsed(body)
+	}
 	log.Trace().Str("method", method).Str("url", url).Msg(body)
-	req, err2 := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err2 := a.doRequest(method, url, body)
 	if err2 != nil {
 		return err2
 	}
-	for k, v := range a.headers {
-		req.Header.Add(k, v)
-		log.Trace().Str("key", k).Str("value", v).Msg("Add HTTP header")
-	}
-	client := http.DefaultClient
-	client.Jar = cookieJar
-	resp, err2 := client.Do(req)
-	if err2 != nil {
-		return err2
+	if resp.StatusCode == http.StatusUnauthorized && a.oauth2 != nil {
+		log.Debug().Str("url", url).Msg("Got 401, refreshing OAuth2 token")
+		resp.Body.Close()
+		if err2 = a.applyOAuth2Token(true); err2 != nil {
+			return err2
+		}
+		if resp, err2 = a.doRequest(method, url, body); err2 != nil {
+			return err2
+		}
 	}
 
 	respBody, err2 := ioutil.ReadAll(resp.Body)
@@ -189,21 +348,84 @@ func (a *apiFeature) sendrequestTo(method, path string, body string) (err error)
 	a.lastStatus = resp.Status
 	a.lastHeaders = map[string]string{}
 	a.lastErrors = []byte("")
+	a.lastTLS = resp.TLS
+	a.lastFormat = ""
 	for k, v := range resp.Header {
 		log.Trace().Str("k", k).Str("v", v[0]).Msg("HDR IN")
 		a.lastHeaders[k] = v[0]
 	}
 	log.Trace().Str("status", resp.Status).Int("code", resp.StatusCode).Msg(string(a.lastBody))
-	return
+	return nil
 }
 
-func (a *apiFeature) theResponseCodeShouldBe(code int) error {
+// requestURL resolves a path against HTTP_ENDPOINT, absolute URLs are kept.
+func (a *apiFeature) requestURL(path string) (string, error) {
+	path = a.getParsed(path)
+	if strings.HasPrefix(path, "http") {
+		return path, nil
+	}
+	if _, ok := a.memory["HTTP_ENDPOINT"]; ok == false {
+		return "", fmt.Errorf("No http endpoint defined. Please set HTTP_ENDPOINT env variable/memory.")
+	}
+	endpoint := a.memory["HTTP_ENDPOINT"].(string)
+	if endpoint == "" {
+		return "", fmt.Errorf("No http endpoint defined. Please set HTTP_ENDPOINT env variable/memory.")
+	}
+	return endpoint + path, nil
+}
+
+func (a *apiFeature) doRequest(method, url, body string) (*http.Response, error) {
+	req, err := a.newRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.client()
+	if err != nil {
+		return nil, err
+	}
+	a.redirects = nil
+	a.lastCookies = nil
+	a.lastRequest = req
+	a.lastRequestBody = body
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	a.lastCookies = append(a.lastCookies, resp.Cookies()...)
+	return resp, nil
+}
+
+// newRequest builds a request with the current headers, signed if a signer
+// is set.
+func (a *apiFeature) newRequest(method, url, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range a.headers {
+		req.Header.Add(k, v)
+		log.Trace().Str("key", k).Str("value", v).Msg("Add HTTP header")
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	if a.signer != nil {
+		if err := a.signer.sign(req, []byte(body)); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func (a *apiFeature) theResponseCodeShouldBe(code int) (err error) {
+	defer a.withCurl(&err)
 	if code != a.lastCode {
 		return fmt.Errorf("expected response code to be: %d, but actual is: %d", code, a.lastCode)
 	}
 	return nil
 }
-func (a *apiFeature) theResponseHeaderShouldMatch(key, value string) error {
+func (a *apiFeature) theResponseHeaderShouldMatch(key, value string) (err error) {
+	defer a.withCurl(&err)
 	key = strings.ToLower(key)
 	log.Trace().Msgf("HEADERS: %#v", a.lastHeaders)
 	for k, v := range a.lastHeaders {
@@ -219,7 +441,8 @@ func (a *apiFeature) theResponseHeaderShouldMatch(key, value string) error {
 	return fmt.Errorf("expected header %s to be: %s, but found no such header", key, value)
 }
 
-func (a *apiFeature) theResponseShouldBe(body *godog.DocString) error {
+func (a *apiFeature) theResponseShouldBe(body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	body.Content = a.getParsed(body.Content)
 
 	if body.Content != string(a.lastBody) {
@@ -229,6 +452,7 @@ func (a *apiFeature) theResponseShouldBe(body *godog.DocString) error {
 }
 
 func (a *apiFeature) theResponseShouldMatchJSON(body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var expected, actual interface{}
 
 	body.Content = a.getParsed(body.Content)
@@ -250,6 +474,7 @@ func (a *apiFeature) theResponseShouldMatchJSON(body *godog.DocString) (err erro
 }
 
 func (a *apiFeature) theResponseErrorsShouldMatchJSON(body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var expected, actual interface{}
 
 	body.Content = a.getParsed(body.Content)
@@ -270,30 +495,28 @@ func (a *apiFeature) theResponseErrorsShouldMatchJSON(body *godog.DocString) (er
 }
 
 func (a *apiFeature) theResponseShouldMatchSubsetOfJSON(body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	opts := jsondiff.DefaultConsoleOptions()
 	body.Content = a.getParsed(body.Content)
 	d, s := jsondiff.Compare(a.lastBody, []byte(body.Content), &opts)
 	switch d {
 	case jsondiff.FullMatch:
 		return nil
-		break
 	case jsondiff.SupersetMatch:
 		return nil
-		break
 	case jsondiff.NoMatch:
 		return fmt.Errorf("No match for [%s] got=[%s] expected=[%s]", s, a.lastBody, body.Content)
-		break
 	default:
 		return fmt.Errorf("Unsupported match type, %d  for [%s] got=[%s] expected=[%s]", d, s, a.lastBody, body.Content)
 	}
-	return nil
 }
 
 func (a *apiFeature) theResponseJsonpathShouldMatch(path, value string) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	path = a.getParsed(path)
 	value = a.getParsed(value)
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -308,10 +531,11 @@ func (a *apiFeature) theResponseJsonpathShouldMatch(path, value string) (err err
 }
 
 func (a *apiFeature) theResponseJsonpathShouldMatchNumber(path, value string) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	path = a.getParsed(path)
 	value = a.getParsed(value)
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -333,10 +557,11 @@ func (a *apiFeature) iUnsetHeader(key string) error {
 }
 
 func (a *apiFeature) theResponseJsonpathShouldMatchBool(path, value string) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	path = a.getParsed(path)
 	value = a.getParsed(value)
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -355,7 +580,7 @@ func (a *apiFeature) theResponseJsonpathShouldMatchFloat(path, value string) (er
 	var v interface{}
 	path = a.getParsed(path)
 	value = a.getParsed(value)
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -370,11 +595,12 @@ func (a *apiFeature) theResponseJsonpathShouldMatchFloat(path, value string) (er
 	return nil
 }
 
-func (a *apiFeature) theResponseJsonpathShouldMatchSubsetOfJson(path string, body *godog.DocString) error {
+func (a *apiFeature) theResponseJsonpathShouldMatchSubsetOfJson(path string, body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	path = a.getParsed(path)
 	body.Content = a.getParsed(body.Content)
-	err := json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -392,22 +618,18 @@ func (a *apiFeature) theResponseJsonpathShouldMatchSubsetOfJson(path string, bod
 	switch d {
 	case jsondiff.FullMatch:
 		return nil
-		break
 	case jsondiff.SupersetMatch:
 		return nil
-		break
 	case jsondiff.NoMatch:
 		return fmt.Errorf("No match for [%s] path=[%s] got=[%s] expected=[%s]", s, path, ress, body.Content)
-		break
 	default:
 		return fmt.Errorf("Unsupported match type, %d  for [%s] path=[%s] got=[%s] expected=[%s]", d, s, path, ress, body.Content)
 	}
-	return nil
 }
 
 func (a *apiFeature) iRememberJsonpathAs(path, key string) error {
 	var v interface{}
-	err := json.Unmarshal(a.lastBody, &v)
+	err := a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -416,13 +638,14 @@ func (a *apiFeature) iRememberJsonpathAs(path, key string) error {
 		return err
 	}
 	a.memory[key] = res
+	delete(a.memoryScopes, key)
 	log.Trace().Str("key", key).Str("val", res.(string)).Msg("Remembered")
 	return nil
 }
 
 func (a *apiFeature) iRememberJqAs(path, key string) error {
 	var v interface{}
-	err := json.Unmarshal(a.lastBody, &v)
+	err := a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -448,10 +671,12 @@ func (a *apiFeature) iRememberJqAs(path, key string) error {
 		actual = v.(string)
 	}
 	a.memory[key] = actual
+	delete(a.memoryScopes, key)
 	return nil
 }
 
-func (a *apiFeature) theResponseJsonpathShouldMatchJson(path string, body *godog.DocString) error {
+func (a *apiFeature) theResponseJsonpathShouldMatchJson(path string, body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	var expected, actual interface{}
 
@@ -460,7 +685,7 @@ func (a *apiFeature) theResponseJsonpathShouldMatchJson(path string, body *godog
 		return err
 	}
 
-	err := json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -476,6 +701,7 @@ func (a *apiFeature) theResponseJsonpathShouldMatchJson(path string, body *godog
 	return nil
 }
 func (a *apiFeature) theResponseJqShouldMatchJson(path string, body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	var expected, actual interface{}
 
@@ -484,7 +710,7 @@ func (a *apiFeature) theResponseJqShouldMatchJson(path string, body *godog.DocSt
 		return err
 	}
 
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -531,6 +757,7 @@ func (a *apiFeature) theResponseJqShouldMatchJson(path string, body *godog.DocSt
 }
 
 func (a *apiFeature) theResponseErrorsJqShouldMatchJson(path string, body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	var expected, actual interface{}
 
@@ -586,6 +813,7 @@ func (a *apiFeature) theResponseErrorsJqShouldMatchJson(path string, body *godog
 }
 
 func (a *apiFeature) theResponseJqShouldMatchSubsetOfJson(path string, body *godog.DocString) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	var expected interface{}
 	var actual string
@@ -595,7 +823,7 @@ func (a *apiFeature) theResponseJqShouldMatchSubsetOfJson(path string, body *god
 		return err
 	}
 
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -629,23 +857,20 @@ func (a *apiFeature) theResponseJqShouldMatchSubsetOfJson(path string, body *god
 	switch d {
 	case jsondiff.FullMatch:
 		return nil
-		break
 	case jsondiff.SupersetMatch:
 		return nil
-		break
 	case jsondiff.NoMatch:
 		return fmt.Errorf("No match for s=[%s] got=[%s] expected=[%s] result=[%s]", s, a.lastBody, body.Content, actual)
-		break
 	default:
 		return fmt.Errorf("Unsupported match type, %d  for s=[%s] got=[%s] expected=[%s] result=[%s]", d, s, a.lastBody, body.Content, actual)
 	}
-	return nil
 
 }
 
 func (a *apiFeature) theResponseJqShouldMatch(path, value string) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -679,8 +904,9 @@ func (a *apiFeature) theResponseJqShouldMatch(path, value string) (err error) {
 }
 
 func (a *apiFeature) theResponseJqShouldMatchNumber(path string, value int) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -720,8 +946,9 @@ func (a *apiFeature) theResponseJqShouldMatchNumber(path string, value int) (err
 }
 
 func (a *apiFeature) theResponseJqShouldMatchFloat(path string, value float64) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -753,8 +980,9 @@ func (a *apiFeature) theResponseJqShouldMatchFloat(path string, value float64) (
 }
 
 func (a *apiFeature) theResponseJqShouldMatchBool(path string, value string) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
-	err = json.Unmarshal(a.lastBody, &v)
+	err = a.unmarshalResponse(&v)
 	if err != nil {
 		return err
 	}
@@ -806,16 +1034,19 @@ func (a *apiFeature) iExecuteQueryToWithVariables(path string, body *godog.DocSt
 */
 func (a *apiFeature) iRememberAs(key, value string) error {
 	a.memory[key] = a.getParsed(value)
+	delete(a.memoryScopes, key)
 	log.Trace().Str("key", key).Str("val", a.memory[key].(string)).Msg("Remembered")
 	return nil
 }
 func (a *apiFeature) iRememberAsBody(key string, value *godog.DocString) error {
-	a.memory[key] = a.getParsed(value.GetContent())
+	a.memory[key] = a.getParsed(value.Content)
+	delete(a.memoryScopes, key)
 	return nil
 }
 func (a *apiFeature) iUnsetMemory(key string) error {
 	log.Trace().Str("key", key).Msg("Memory unset")
 	delete(a.memory, key)
+	delete(a.memoryScopes, key)
 	return nil
 }
 func (a *apiFeature) iSetVariableAs(key, value string) error {
@@ -823,7 +1054,7 @@ func (a *apiFeature) iSetVariableAs(key, value string) error {
 	return nil
 }
 func (a *apiFeature) iSetVariableAsMultiline(key string, value *godog.DocString) error {
-	a.variables[key] = a.getParsed(value.GetContent())
+	a.variables[key] = a.getParsed(value.Content)
 	return nil
 }
 
@@ -844,6 +1075,144 @@ func (a *apiFeature) iSetVariableAsBool(key string, value string) error {
 	a.variables[key] = v
 	return nil
 }
+func (a *apiFeature) iSetVariableAsJSON(key string, value *godog.DocString) error {
+	var v interface{}
+	if err := json.Unmarshal([]byte(a.getParsed(value.Content)), &v); err != nil {
+		return fmt.Errorf("Cannot parse variable %s as json: %s", key, err)
+	}
+	a.variables[key] = v
+	return nil
+}
+func (a *apiFeature) iSetVariablesFromJSON(body *godog.DocString) error {
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(a.getParsed(body.Content)), &v); err != nil {
+		return fmt.Errorf("Cannot parse variables as json object: %s", err)
+	}
+	for k, val := range v {
+		a.variables[k] = val
+	}
+	return nil
+}
+
+// iSetVariablesFromTable sets variables from a table with "name", "value" and
+// optional "type" columns. Dotted names like "input.address.city" build nested
+// input objects.
+func (a *apiFeature) iSetVariablesFromTable(table *godog.Table) error {
+	if len(table.Rows) < 2 {
+		return fmt.Errorf("Expected a header row and at least one variable row")
+	}
+	columns := map[string]int{}
+	for i, cell := range table.Rows[0].Cells {
+		columns[strings.ToLower(cell.Value)] = i
+	}
+	nameCol, ok := columns["name"]
+	if !ok {
+		return fmt.Errorf("Missing \"name\" column in variables table")
+	}
+	valueCol, ok := columns["value"]
+	if !ok {
+		return fmt.Errorf("Missing \"value\" column in variables table")
+	}
+	typeCol, hasType := columns["type"]
+	for _, row := range table.Rows[1:] {
+		typ := ""
+		if hasType {
+			typ = row.Cells[typeCol].Value
+		}
+		name := row.Cells[nameCol].Value
+		v, err := parseTypedValue(typ, a.getParsed(row.Cells[valueCol].Value))
+		if err != nil {
+			return fmt.Errorf("Cannot set variable %s: %s", name, err)
+		}
+		if err := setNested(a.variables, strings.Split(name, "."), v); err != nil {
+			return fmt.Errorf("Cannot set variable %s: %s", name, err)
+		}
+	}
+	return nil
+}
+func (a *apiFeature) iSetVariableFromJqOnResponse(key, path string) error {
+	var v interface{}
+	if err := a.unmarshalResponse(&v); err != nil {
+		return err
+	}
+	res, err := jqLastValue(a.getParsed(path), v)
+	if err != nil {
+		return err
+	}
+	a.variables[key] = res
+	log.Trace().Str("key", key).Interface("val", res).Msg("Variable set from jq")
+	return nil
+}
+
+func parseTypedValue(typ, value string) (interface{}, error) {
+	switch strings.ToLower(typ) {
+	case "", "string":
+		return value, nil
+	case "number", "int":
+		return strconv.Atoi(value)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "bool", "boolean":
+		return strconv.ParseBool(value)
+	case "null":
+		return nil, nil
+	case "string list", "list":
+		list := []string{}
+		for _, s := range strings.Split(value, ",") {
+			list = append(list, strings.TrimSpace(s))
+		}
+		return list, nil
+	case "json":
+		var v interface{}
+		err := json.Unmarshal([]byte(value), &v)
+		return v, err
+	default:
+		return nil, fmt.Errorf("Unsupported type %s", typ)
+	}
+}
+
+func setNested(m map[string]interface{}, path []string, value interface{}) error {
+	if len(path) == 1 {
+		m[path[0]] = value
+		return nil
+	}
+	child, ok := m[path[0]]
+	if !ok || child == nil {
+		child = map[string]interface{}{}
+		m[path[0]] = child
+	}
+	cm, ok := child.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s is not an object", path[0])
+	}
+	return setNested(cm, path[1:], value)
+}
+
+// jqLastValue runs a jq query and returns its last result, like the jq
+// assertions do.
+func jqLastValue(path string, v interface{}) (interface{}, error) {
+	query, err := gojq.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	var actual interface{}
+	iter := code.Run(v)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		actual = v
+	}
+	return actual, nil
+}
 func (a *apiFeature) iUnsetVariable(key string) error {
 	log.Trace().Str("key", key).Msg("Variable unset")
 	delete(a.variables, key)
@@ -873,11 +1242,13 @@ func (a *apiFeature) iLoadVariablesFromDirectory(dirname string) error {
 		key := strings.TrimSuffix(file.Name(), ".graphql")
 		log.Trace().Str("key", key).Str("value", string(content)).Msg("Setting content to memory")
 		a.memory[key] = string(content)
+		delete(a.memoryScopes, key)
 	}
 	return nil
 }
 
 func (a *apiFeature) theResponseErrorsJqShouldMatchNumber(path string, value int) (err error) {
+	defer a.withCurl(&err)
 	var v interface{}
 	if string(a.lastErrors) == "" {
 		a.lastErrors = []byte(`[]`)
@@ -975,50 +1346,132 @@ func (a *apiFeature) iSetHTTPHeaderAs(key, value string) error {
 }
 func (a *apiFeature) iDumpMemory() error {
 	for k, v := range a.memory {
-		log.Info().Str("key", k).Str("val", v.(string)).Msg("Memory dump")
+		log.Info().Str("key", k).Str("val", maskValue(k, dumpValue(v))).Str("scope", a.memoryScope(k)).Msg("Memory dump")
 	}
 	return nil
 }
 func (a *apiFeature) iDumpVariables() error {
 	for k, v := range a.variables {
-		log.Info().Str("key", k).Str("val", v.(string)).Msg("Variable dump")
+		log.Info().Str("key", k).Str("val", maskValue(k, dumpValue(v))).Msg("Variable dump")
 	}
 	return nil
 }
 func (a *apiFeature) iDumpHeaders() error {
 	for k, v := range a.headers {
-		log.Info().Str("key", k).Str("val", v).Msg("Header dump")
+		log.Info().Str("key", k).Str("val", maskValue(k, v)).Msg("Header dump")
 	}
 	return nil
 }
 func (a *apiFeature) iDumpResponseHeaders() error {
 	for k, v := range a.lastHeaders {
-		log.Info().Str("key", k).Str("val", v).Msg("Response header dump")
+		log.Info().Str("key", k).Str("val", maskValue(k, v)).Msg("Response header dump")
 	}
 	return nil
 }
 func (a *apiFeature) iDumpResponseAsJSON() error {
-	fmt.Println(string(pretty.Color(pretty.Pretty(a.lastBody), nil)))
+	fmt.Println(maskSecrets(string(pretty.Color(pretty.Pretty(a.lastBody), nil))))
+	return nil
+}
+
+// dumpValue shows strings as they are, other values as JSON and missing
+// values as empty.
+func dumpValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(content)
+}
+
+// iDumpStateAsJSON prints memory, variables, headers and the last request
+// and response as one document, with secrets masked.
+func (a *apiFeature) iDumpStateAsJSON() error {
+	masked := func(values map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{}
+		for k, v := range values {
+			if isSecretKey(k) {
+				v = maskValue(k, dumpValue(v))
+			}
+			m[k] = v
+		}
+		return m
+	}
+	headers := map[string]interface{}{}
+	for k, v := range a.headers {
+		headers[k] = v
+	}
+	responseHeaders := map[string]interface{}{}
+	for k, v := range a.lastHeaders {
+		responseHeaders[k] = v
+	}
+	state := map[string]interface{}{
+		"memory":    masked(a.memory),
+		"variables": masked(a.variables),
+		"headers":   masked(headers),
+		"request":   nil,
+		"response": map[string]interface{}{
+			"code":    a.lastCode,
+			"status":  a.lastStatus,
+			"headers": masked(responseHeaders),
+			"body":    jsonOrString(a.lastBody),
+		},
+	}
+	if req := a.lastRequest; req != nil {
+		requestHeaders := map[string]interface{}{}
+		for k, v := range req.Header {
+			requestHeaders[k] = strings.Join(v, ", ")
+		}
+		state["request"] = map[string]interface{}{
+			"method":  req.Method,
+			"url":     req.URL.String(),
+			"headers": masked(requestHeaders),
+			"body":    jsonOrString([]byte(a.lastRequestBody)),
+		}
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(maskSecrets(string(content)))
 	return nil
 }
+
+// jsonOrString embeds JSON bodies as they are, other bodies as strings.
+func jsonOrString(body []byte) interface{} {
+	if len(body) > 0 && json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
+
 func (a *apiFeature) iShowMemoryKey(key string) error {
-	fmt.Printf("[Memory \"%s\": \"%v\"]\n", key, a.memory[key])
-	log.Info().Str("key", key).Str("val", a.memory[key].(string)).Msg("Memory value")
+	value := maskValue(key, dumpValue(a.memory[key]))
+	fmt.Printf("[Memory \"%s\" (%s): \"%v\"]\n", key, a.memoryScope(key), value)
+	log.Info().Str("key", key).Str("val", value).Str("scope", a.memoryScope(key)).Msg("Memory value")
 	return nil
 }
 func (a *apiFeature) iShowVariableKey(key string) error {
-	fmt.Printf("[Variable \"%s\": \"%v\"]\n", key, a.variables[key])
-	log.Info().Str("key", key).Str("val", a.variables[key].(string)).Msg("Variable value")
+	value := maskValue(key, dumpValue(a.variables[key]))
+	fmt.Printf("[Variable \"%s\": \"%v\"]\n", key, value)
+	log.Info().Str("key", key).Str("val", value).Msg("Variable value")
 	return nil
 }
 func (a *apiFeature) iShowHeaderKey(key string) error {
-	fmt.Printf("[Header \"%s\": \"%v\"]\n", key, a.headers[key])
-	log.Info().Str("key", key).Str("val", a.headers[key]).Msg("Header value")
+	value := maskValue(key, a.headers[key])
+	fmt.Printf("[Header \"%s\": \"%v\"]\n", key, value)
+	log.Info().Str("key", key).Str("val", value).Msg("Header value")
 	return nil
 }
 func (a *apiFeature) iShowResponseHeaderKey(key string) error {
-	fmt.Printf("[Response header \"%s\": \"%v\"]\n", key, a.lastHeaders[key])
-	log.Info().Str("key", key).Str("val", a.lastHeaders[key]).Msg("Response header value")
+	value := maskValue(key, a.lastHeaders[key])
+	fmt.Printf("[Response header \"%s\": \"%v\"]\n", key, value)
+	log.Info().Str("key", key).Str("val", value).Msg("Response header value")
 	return nil
 }
 
@@ -1031,10 +1484,7 @@ func (a *apiFeature) iResetHeaders() error {
 	return nil
 }
 func (a *apiFeature) iResetMemory() error {
-	a.memory = map[string]interface{}{}
-	for k, v := range defaultMemory {
-		a.memory[k] = v
-	}
+	a.seedMemory()
 	return nil
 }
 
@@ -1051,6 +1501,12 @@ func seedDefaultMemory() {
 			log.Debug().Int("k", k).Str("key", v).Str("value", value).Msg("Seeding default memory")
 		}
 	}
+	// reset settings, including named ones like RESET_USERS_ENDPOINT
+	for _, env := range os.Environ() {
+		if kv := strings.SplitN(env, "=", 2); strings.HasPrefix(kv[0], "RESET_") && kv[1] != "" {
+			defaultMemory[kv[0]] = kv[1]
+		}
+	}
 }
 
 func init() {
@@ -1060,6 +1516,10 @@ func init() {
 	if err := godotenv.Load(); err != nil {
 		log.Warn().Msg("File .env not found, reading configuration from ENV")
 	}
+	flag.StringVar(&setupFeatures, "setup", os.Getenv("SETUP"), "Comma separated feature files run once before the suite")
+	flag.StringVar(&teardownFeatures, "teardown", os.Getenv("TEARDOWN"), "Comma separated feature files run once after the suite")
+	flag.StringVar(&openapiSpec, "openapi", os.Getenv("OPENAPI"), "OpenAPI spec to validate every request and response against")
+	flag.StringVar(&openapiMode, "openapi-mode", os.Getenv("OPENAPI_MODE"), "Report OpenAPI violations as step failures (fail) or warnings (warn)")
 
 	LOGLEVEL := os.Getenv("LOGLEVEL")
 	switch LOGLEVEL {
@@ -1092,11 +1552,13 @@ func init() {
 	LOGFORMAT := os.Getenv("LOGFORMAT")
 	switch LOGFORMAT {
 	case "console":
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+		log.Logger = log.Output(secretWriter{zerolog.ConsoleWriter{Out: os.Stderr}})
 		break
 	case "", "json":
+		log.Logger = log.Output(secretWriter{os.Stderr})
 		break
 	default:
+		log.Logger = log.Output(secretWriter{os.Stderr})
 		log.Warn().Str("LOGFORMAT", LOGFORMAT).Msg("Unsupported format")
 		break
 	}
@@ -1120,14 +1582,31 @@ func init() {
 func InitializeScenario(s *godog.ScenarioContext) {
 	api := &apiFeature{URL: "http://localhost:9903/api"}
 
-	s.BeforeScenario(api.resetResponse)
+	s.Before(api.resetResponse)
+	s.After(api.closeScenario)
+	s.BeforeStep(func(*godog.Step) { api.registerSecrets() })
+	s.AfterStep(func(*godog.Step, error) { api.registerSecrets() })
 
 	s.Step(`^I send "(GET|POST|PUT|DELETE)" request to "([^"]*)"$`, api.iSendrequestTo)
 	s.Step(`^I send "(GET|POST|PUT|DELETE)" request to "([^"]*)" with data:$`, api.iSendrequestToWithData)
+	s.Step(`^I send "(GET|POST|PUT|DELETE)" request to "([^"]*)" with body from file "([^"]*)"$`, api.iSendrequestToWithBodyFromFile)
+	s.Step(`^I send "(GET|POST|PUT|DELETE)" request to "([^"]*)" with templated body from file "([^"]*)"$`, api.iSendrequestToWithTemplatedBodyFromFile)
+	s.Step(`^I save the response body to "([^"]*)"$`, api.iSaveTheResponseBodyTo)
+	s.Step(`^the response body size should be (\d+) bytes$`, api.theResponseBodySizeShouldBe)
+	s.Step(`^the response body SHA-256 should be "([^"]*)"$`, api.theResponseBodySHA256ShouldBe)
+	s.Step(`^the response body should be sniffed as "([^"]*)"$`, api.theResponseBodyShouldBeSniffedAs)
 
 	s.Step(`^the response code should be (\d+)$`, api.theResponseCodeShouldBe)
 	s.Step(`^the response header "([^"]*)" should match "([^"]*)"$`, api.theResponseHeaderShouldMatch)
+	s.Step(`^the redirect chain should be:$`, api.theRedirectChainShouldBe)
+	s.Step(`^the cookie "([^"]*)" should be set$`, api.theCookieShouldBeSet)
+	s.Step(`^the cookie "([^"]*)" should not be set$`, api.theCookieShouldNotBeSet)
+	s.Step(`^the cookie "([^"]*)" should be set with attributes (.+)$`, api.theCookieShouldBeSetWithAttributes)
+	s.Step(`^the server certificate should expire after "([^"]*)"$`, api.theServerCertificateShouldExpireAfter)
+	s.Step(`^the server certificate should be valid for "([^"]*)"$`, api.theServerCertificateShouldBeValidFor)
+	s.Step(`^the server certificate issuer should be "([^"]*)"$`, api.theServerCertificateIssuerShouldBe)
 	s.Step(`^the response should be:$`, api.theResponseShouldBe)
+	s.Step(`^the response should conform to the OpenAPI spec$`, api.theResponseShouldConformToTheOpenAPISpec)
 
 	s.Step(`^the response should match json:$`, api.theResponseShouldMatchJSON)
 	s.Step(`^the response should match subset of json:$`, api.theResponseShouldMatchSubsetOfJSON)
@@ -1144,6 +1623,42 @@ func InitializeScenario(s *godog.ScenarioContext) {
 	s.Step(`^the response jq "([^"]*)" should match bool "([^"]*)"$`, api.theResponseJqShouldMatchBool)
 	s.Step(`^the response jq "([^"]*)" should match json:$`, api.theResponseJqShouldMatchJson)
 	s.Step(`^the response jq "([^"]*)" should match subset of json:$`, api.theResponseJqShouldMatchSubsetOfJson)
+	s.Step(`^the response jq "([^"]*)" should be a JWT with claim "([^"]*)" equal to "([^"]*)"$`, api.theResponseJqShouldBeAJWTWithClaimEqualTo)
+	s.Step(`^the response jq "([^"]*)" should be a JWT signed with "([^"]*)" using "([^"]*)"$`, api.theResponseJqShouldBeAJWTSignedWithUsing)
+	s.Step(`^the response jq "([^"]*)" should be a JWT expiring after "([^"]*)"$`, api.theResponseJqShouldBeAJWTExpiringAfter)
+	s.Step(`^the response jq "([^"]*)" should be an expired JWT$`, api.theResponseJqShouldBeAnExpiredJWT)
+
+	s.Step(`^the response xpath "([^"]*)" should match "([^"]*)"$`, api.theResponseXpathShouldMatch)
+	s.Step(`^the response xpath "([^"]*)" should match (\d+) nodes$`, api.theResponseXpathShouldMatchNodes)
+	s.Step(`^the response css "([^"]*)" text should be "([^"]*)"$`, api.theResponseCssTextShouldBe)
+	s.Step(`^the response css "([^"]*)" attribute "([^"]*)" should be "([^"]*)"$`, api.theResponseCssAttributeShouldBe)
+	s.Step(`^the response css "([^"]*)" should match (\d+) elements$`, api.theResponseCssShouldMatchElements)
+	s.Step(`^I register XML namespace "([^"]*)" as "([^"]*)"$`, api.iRegisterXMLNamespaceAs)
+
+	s.Step(`^I open event stream "(GET|POST)" "([^"]*)"$`, api.iOpenEventStream)
+	s.Step(`^I close event stream$`, api.iCloseEventStream)
+	s.Step(`^within "([^"]*)" I should receive an event "([^"]*)"$`, api.withinIShouldReceiveAnEvent)
+	s.Step(`^within "([^"]*)" I should receive an event "([^"]*)" whose data jq "([^"]*)" matches "([^"]*)"$`, api.withinIShouldReceiveAnEventWhoseDataJqMatches)
+	s.Step(`^within "([^"]*)" I should receive an event "([^"]*)" and remember data jq "([^"]*)" as "([^"]*)"$`, api.withinIShouldReceiveAnEventAndRememberDataJqAs)
+	s.Step(`^I use proto files "([^"]*)"$`, api.iUseProtoFiles)
+	s.Step(`^I call gRPC method "([^"]*)" on "([^"]*)"$`, api.iCallGRPCMethodOn)
+	s.Step(`^I call gRPC method "([^"]*)" on "([^"]*)" with json:$`, api.iCallGRPCMethodOnWithJSON)
+	s.Step(`^the gRPC status code should be "([^"]*)"$`, api.theGRPCStatusCodeShouldBe)
+	s.Step(`^the gRPC status message should be "([^"]*)"$`, api.theGRPCStatusMessageShouldBe)
+	s.Step(`^the gRPC trailer "([^"]*)" should match "([^"]*)"$`, api.theGRPCTrailerShouldMatch)
+	s.Step(`^I connect to websocket "([^"]*)"$`, api.iConnectToWebsocket)
+	s.Step(`^I connect to websocket "([^"]*)" with subprotocols "([^"]*)"$`, api.iConnectToWebsocketWithSubprotocols)
+	s.Step(`^the websocket subprotocol should be "([^"]*)"$`, api.theWebsocketSubprotocolShouldBe)
+	s.Step(`^I send websocket message "([^"]*)"$`, api.iSendWebsocketMessage)
+	s.Step(`^I send websocket message:$`, api.iSendWebsocketMessageBody)
+	s.Step(`^I send websocket json:$`, api.iSendWebsocketJSON)
+	s.Step(`^within "([^"]*)" I should receive a websocket message "([^"]*)"$`, api.withinIShouldReceiveAWebsocketMessage)
+	s.Step(`^within "([^"]*)" I should receive a websocket message whose jq "([^"]*)" matches "([^"]*)"$`, api.withinIShouldReceiveAWebsocketMessageWhoseJqMatches)
+	s.Step(`^within "([^"]*)" I should receive a websocket message and remember jq "([^"]*)" as "([^"]*)"$`, api.withinIShouldReceiveAWebsocketMessageAndRememberJqAs)
+	s.Step(`^I close websocket$`, api.iCloseWebsocket)
+	s.Step(`^I close websocket with code (\d+)$`, api.iCloseWebsocketWithCode)
+	s.Step(`^within "([^"]*)" the websocket should be closed with code (\d+)$`, api.withinTheWebsocketShouldBeClosedWithCode)
+	s.Step(`^the response is parsed as (json|yaml|csv|csv with header|ndjson)$`, api.theResponseIsParsedAs)
 
 	s.Step(`^the response errors should match json:$`, api.theResponseErrorsShouldMatchJSON)
 	s.Step(`^the response errors jq "([^"]*)" should match json:$`, api.theResponseErrorsJqShouldMatchJson)
@@ -1152,8 +1667,17 @@ func InitializeScenario(s *godog.ScenarioContext) {
 	s.Step(`^I remember response jsonpath "([^"]*)" as "([^"]*)"$`, api.iRememberJsonpathAs)
 	s.Step(`^I remember jsonpath "([^"]*)" as "([^"]*)"$`, api.iRememberJsonpathAs) //@deprecated  backward compatibility
 	s.Step(`^I remember response jq "([^"]*)" as "([^"]*)"$`, api.iRememberJqAs)
+	s.Step(`^I remember response xpath "([^"]*)" as "([^"]*)"$`, api.iRememberResponseXpathAs)
+	s.Step(`^I remember response css "([^"]*)" text as "([^"]*)"$`, api.iRememberResponseCssTextAs)
+	s.Step(`^I remember response css "([^"]*)" attribute "([^"]*)" as "([^"]*)"$`, api.iRememberResponseCssAttributeAs)
+	s.Step(`^I remember JWT claim "([^"]*)" from response jq "([^"]*)" as "([^"]*)"$`, api.iRememberJWTClaimFromResponseJqAs)
 	s.Step(`^I remember "([^"]*)" as "([^"]*)"$`, api.iRememberAs)
+	s.Step(`^I remember cookie "([^"]*)" as "([^"]*)"$`, api.iRememberCookieAs)
 	s.Step(`^I remember "([^"]*)" as:$`, api.iRememberAsBody)
+	s.Step(`^I remember globally "([^"]*)" as "([^"]*)"$`, api.iRememberGloballyAs)
+	s.Step(`^I remember globally "([^"]*)" as:$`, api.iRememberGloballyAsBody)
+	s.Step(`^I remember for feature "([^"]*)" as "([^"]*)"$`, api.iRememberForFeatureAs)
+	s.Step(`^I remember for feature "([^"]*)" as:$`, api.iRememberForFeatureAsBody)
 
 	s.Step(`^I set variable "([^"]*)" as "([^"]*)"$`, api.iSetVariableAs)
 	s.Step(`^I set variable "([^"]*)" as:$`, api.iSetVariableAsMultiline)
@@ -1161,8 +1685,34 @@ func InitializeScenario(s *godog.ScenarioContext) {
 	s.Step(`^I set variable "([^"]*)" as number "([^"]*)"$`, api.iSetVariableAsNumber)
 	s.Step(`^I set variable "([^"]*)" as float "([^"]*)"$`, api.iSetVariableAsFloat)
 	s.Step(`^I set variable "([^"]*)" as boolean "([^"]*)"$`, api.iSetVariableAsBool)
+	s.Step(`^I set variable "([^"]*)" as json:$`, api.iSetVariableAsJSON)
+	s.Step(`^I set variable "([^"]*)" from jq "([^"]*)" on response$`, api.iSetVariableFromJqOnResponse)
+	s.Step(`^I set variables from json:$`, api.iSetVariablesFromJSON)
+	s.Step(`^I set variables:$`, api.iSetVariablesFromTable)
 
 	s.Step(`^I set HTTP header "([^"]*)" as "([^"]*)"$`, api.iSetHTTPHeaderAs)
+	s.Step(`^I authenticate with OAuth2 client credentials using "([^"]*)"$`, api.iAuthenticateWithOAuth2ClientCredentialsUsing)
+	s.Step(`^I authenticate with OAuth2 password grant using "([^"]*)"$`, api.iAuthenticateWithOAuth2PasswordGrantUsing)
+	s.Step(`^I sign requests with AWS SigV4 for service "([^"]*)" region "([^"]*)"$`, api.iSignRequestsWithAWSSigV4ForServiceRegion)
+	s.Step(`^I sign requests with HMAC-SHA256 using key "([^"]*)" into header "([^"]*)"$`, api.iSignRequestsWithHMACSHA256UsingKeyIntoHeader)
+	s.Step(`^I sign requests with HTTP message signature "([^"]*)" using key "([^"]*)" with key id "([^"]*)" covering "([^"]*)"$`, api.iSignRequestsWithHTTPMessageSignatures)
+	s.Step(`^I do not sign requests$`, api.iDoNotSignRequests)
+	s.Step(`^I use client certificate "([^"]*)" with key "([^"]*)"$`, api.iUseClientCertificateWithKey)
+	s.Step(`^I trust CA certificates from "([^"]*)"$`, api.iTrustCACertificatesFrom)
+	s.Step(`^I set TLS server name to "([^"]*)"$`, api.iSetTLSServerNameTo)
+	s.Step(`^I skip TLS verification$`, api.iSkipTLSVerification)
+	s.Step(`^I use proxy "([^"]*)"$`, api.iUseProxy)
+	s.Step(`^I do not use proxy$`, api.iDoNotUseProxy)
+	s.Step(`^I connect to unix socket "([^"]*)"$`, api.iConnectToUnixSocket)
+	s.Step(`^I resolve "([^"]*)" to "([^"]*)"$`, api.iResolveTo)
+	s.Step(`^I set cookie "([^"]*)" to "([^"]*)"$`, api.iSetCookieTo)
+	s.Step(`^I set cookie "([^"]*)" to "([^"]*)" for "([^"]*)"$`, api.iSetCookieToFor)
+	s.Step(`^I delete cookie "([^"]*)"$`, api.iDeleteCookie)
+	s.Step(`^I delete cookie "([^"]*)" for "([^"]*)"$`, api.iDeleteCookieFor)
+	s.Step(`^I clear cookies$`, api.iClearCookies)
+	s.Step(`^I do not follow redirects$`, api.iDoNotFollowRedirects)
+	s.Step(`^I follow at most "(\d+)" redirects$`, api.iFollowAtMostRedirects)
+	s.Step(`^I follow redirects$`, api.iFollowRedirects)
 
 	s.Step(`^I execute query "([^"]*)"$`, api.iExecuteQuery)
 	s.Step(`^I wait "([^"]*)" seconds$`, api.iWaitSeconds)
@@ -1171,7 +1721,12 @@ func InitializeScenario(s *godog.ScenarioContext) {
 	s.Step(`^I dump variables$`, api.iDumpVariables)
 	s.Step(`^I dump headers$`, api.iDumpHeaders)
 	s.Step(`^I dump response headers$`, api.iDumpResponseHeaders)
+	s.Step(`^I dump request as curl$`, api.iDumpRequestAsCurl)
+	s.Step(`^I dump state as JSON$`, api.iDumpStateAsJSON)
 	s.Step(`^I dump response as JSON$`, api.iDumpResponseAsJSON)
+	s.Step(`^I dump redirect chain$`, api.iDumpRedirectChain)
+	s.Step(`^I dump cookies$`, api.iDumpCookies)
+	s.Step(`^I dump cookies for "([^"]*)"$`, api.iDumpCookiesFor)
 
 	s.Step(`^I show memory key "([^"]*)"$`, api.iShowMemoryKey)
 	s.Step(`^I show variable key "([^"]*)"$`, api.iShowVariableKey)
@@ -1184,8 +1739,17 @@ func InitializeScenario(s *godog.ScenarioContext) {
 	s.Step(`^I unset variable "([^"]*)"$`, api.iUnsetVariable)
 	s.Step(`^I unset header "([^"]*)"$`, api.iUnsetHeader)
 	s.Step(`^I unset memory "([^"]*)"$`, api.iUnsetMemory)
+	s.Step(`^I unset global memory "([^"]*)"$`, api.iUnsetGlobalMemory)
+	s.Step(`^I unset feature memory "([^"]*)"$`, api.iUnsetFeatureMemory)
 
 	s.Step(`^I load variables from directory "([^"]*)"$`, api.iLoadVariablesFromDirectory)
+	s.Step(`^I use dataset row:$`, api.iUseDatasetRow)
+
+	s.Step(`^the database "([^"]*)" contains rows in table "([^"]*)":$`, api.theDatabaseContainsRowsInTable)
+	s.Step(`^I execute SQL on "([^"]*)":$`, api.iExecuteSQLOn)
+	s.Step(`^the query "([^"]*)" on "([^"]*)" should return "([^"]*)"$`, api.theQueryOnShouldReturn)
+	s.Step(`^the query "([^"]*)" on "([^"]*)" should return rows:$`, api.theQueryOnShouldReturnRows)
+	s.Step(`^I remember SQL query "([^"]*)" on "([^"]*)" as "([^"]*)"$`, api.iRememberSQLQueryOnAs)
 
 }
 
@@ -1236,6 +1800,9 @@ func After(s, f string) string {
 		log.Error().Err(err).Str("dur", s).Msg("Cannot parse time duration")
 		panic(err)
 	}
+	if f == "unix" {
+		return strconv.FormatInt(time.Now().Add(d).Unix(), 10)
+	}
 	return time.Now().UTC().Add(d).Format(getTimeFormat(f))
 }
 
@@ -1246,6 +1813,9 @@ func main() {
 		}
 	}
 	flag.Parse()
+	if command, ok := commands[flag.Arg(0)]; ok {
+		os.Exit(command(flag.Args()[1:]))
+	}
 	funcMap = template.FuncMap{
 		"now":        time.Now,
 		"after":      After,
@@ -1253,15 +1823,50 @@ func main() {
 		"ksuid":      ExampleKSUID,
 		"betterguid": betterguid.New,
 		"getenv":     os.Getenv,
+		"jwt":        JWT,
+		"dict":       Dict,
+		"file":       ReadFile,
 	}
 
 	seedDefaultMemory()
-	opt.Paths = flag.Args()
+	paths, err := expandDatasets(flag.Args())
+	if err == nil {
+		paths, err = prepareSuiteFeatures(paths)
+	}
+	if err == nil {
+		err = loadOpenAPI()
+	}
+	if err == nil {
+		err = loadSecrets()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot load features")
+		cleanupDatasets()
+		fmt.Println("GHATT COMMAND LINE ERROR")
+		os.Exit(2)
+	}
+	opt.Paths = paths
+	excludeSuiteTags(&opt)
 	status := godog.TestSuite{
-		Name:                "godogs",
-		ScenarioInitializer: InitializeScenario,
-		Options:             &opt,
+		Name:                 "godogs",
+		TestSuiteInitializer: InitializeTestSuite,
+		ScenarioInitializer:  InitializeScenario,
+		Options:              &opt,
 	}.Run()
+	if status == 0 && teardownFailed {
+		status = 1
+	}
+	if stopOutcome != "" {
+		status = 1
+		fmt.Println(stopOutcome)
+	} else {
+		printOutcome(status)
+	}
+	cleanupDatasets()
+	os.Exit(status)
+}
+
+func printOutcome(status int) {
 	switch status {
 	case 0:
 		fmt.Println("GHATT SUCCESS")
@@ -1276,5 +1881,4 @@ func main() {
 		fmt.Println("GHATT OS ERROR")
 		break
 	}
-	os.Exit(status)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTypedValue(t *testing.T) {
+	tests := []struct {
+		typ     string
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"", "42", "42", false},
+		{"string", "a b", "a b", false},
+		{"number", "42", 42, false},
+		{"int", "-1", -1, false},
+		{"number", "4.2", nil, true},
+		{"float", "4.2", 4.2, false},
+		{"bool", "true", true, false},
+		{"Boolean", "false", false, false},
+		{"bool", "yes", nil, true},
+		{"null", "anything", nil, false},
+		{"list", "a, b ,c", []string{"a", "b", "c"}, false},
+		{"string list", "", []string{""}, false},
+		{"json", `{"a": [1, "x", null]}`, map[string]interface{}{"a": []interface{}{float64(1), "x", nil}}, false},
+		{"json", "{", nil, true},
+		{"date", "2021-01-01", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+" "+tt.value, func(t *testing.T) {
+			got, err := parseTypedValue(tt.typ, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetNested(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial map[string]interface{}
+		path    []string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"top level", map[string]interface{}{}, []string{"a"}, map[string]interface{}{"a": 1}, false},
+		{"creates objects", map[string]interface{}{}, []string{"a", "b", "c"},
+			map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}}}, false},
+		{"keeps siblings", map[string]interface{}{"a": map[string]interface{}{"x": 2}}, []string{"a", "b"},
+			map[string]interface{}{"a": map[string]interface{}{"x": 2, "b": 1}}, false},
+		{"replaces null", map[string]interface{}{"a": nil}, []string{"a", "b"},
+			map[string]interface{}{"a": map[string]interface{}{"b": 1}}, false},
+		{"overwrites leaf", map[string]interface{}{"a": "old"}, []string{"a"}, map[string]interface{}{"a": 1}, false},
+		{"through string", map[string]interface{}{"a": "x"}, []string{"a", "b"}, nil, true},
+		{"through list", map[string]interface{}{"a": []interface{}{}}, []string{"a", "b"}, nil, true},
+		{"through nested number", map[string]interface{}{"a": map[string]interface{}{"b": 3}}, []string{"a", "b", "c"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := setNested(tt.initial, tt.path, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.initial, tt.want) {
+				t.Errorf("got %#v, want %#v", tt.initial, tt.want)
+			}
+		})
+	}
+}
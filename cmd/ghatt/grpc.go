@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cucumber/godog"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// headers of the HTTP steps which must not be sent as gRPC metadata
+var grpcReservedHeaders = map[string]bool{
+	"host":           true,
+	"content-type":   true,
+	"content-length": true,
+	"user-agent":     true,
+	"te":             true,
+	"connection":     true,
+}
+
+func (a *apiFeature) iCallGRPCMethodOn(method, endpoint string) error {
+	return a.callGRPC(method, endpoint, "{}")
+}
+func (a *apiFeature) iCallGRPCMethodOnWithJSON(method, endpoint string, body *godog.DocString) error {
+	return a.callGRPC(method, endpoint, body.Content)
+}
+
+// callGRPC invokes a unary or server streaming method, storing the response
+// as JSON in lastBody (an array for streams) so body steps work unchanged.
+// Endpoint is a memory/env key, or an address if no such key is set.
+func (a *apiFeature) callGRPC(method, endpoint, body string) error {
+	address := a.setting(endpoint)
+	if address == "" {
+		address = a.getParsed(endpoint)
+	}
+	method = strings.TrimPrefix(a.getParsed(method), "/")
+	i := strings.LastIndex(method, "/")
+	if i < 0 {
+		return fmt.Errorf("Cannot parse gRPC method %s, expected package.Service/Method", method)
+	}
+	timeout := 30 * time.Second
+	if t := a.setting("GRPC_TIMEOUT"); t != "" {
+		var err error
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := a.grpcConn(ctx, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	md, err := a.grpcMethod(ctx, conn, method[:i], method[i+1:])
+	if err != nil {
+		return err
+	}
+	if md.IsClientStreaming() {
+		return fmt.Errorf("Client streaming gRPC method %s is not supported", method)
+	}
+	req := dynamic.NewMessage(md.GetInputType())
+	if err := req.UnmarshalJSON([]byte(a.getParsed(body))); err != nil {
+		return fmt.Errorf("Cannot parse %s request: %s", md.GetInputType().GetFullyQualifiedName(), err)
+	}
+
+	outgoing := metadata.MD{}
+	for k, v := range a.headers {
+		if k = strings.ToLower(k); !grpcReservedHeaders[k] {
+			outgoing.Append(k, v)
+		}
+	}
+	ctx = metadata.NewOutgoingContext(ctx, outgoing)
+	var header, trailer metadata.MD
+	stub := grpcdynamic.NewStub(conn)
+	log.Trace().Str("address", address).Str("method", method).Msg(body)
+
+	var responses []proto.Message
+	if md.IsServerStreaming() {
+		var stream *grpcdynamic.ServerStream
+		if stream, err = stub.InvokeRpcServerStream(ctx, md, req); err == nil {
+			for {
+				var resp proto.Message
+				if resp, err = stream.RecvMsg(); err != nil {
+					break
+				}
+				responses = append(responses, resp)
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			header, _ = stream.Header()
+			trailer = stream.Trailer()
+		}
+	} else {
+		var resp proto.Message
+		if resp, err = stub.InvokeRpc(ctx, md, req, grpc.Header(&header), grpc.Trailer(&trailer)); err == nil {
+			responses = append(responses, resp)
+		}
+	}
+	st := status.New(codes.OK, "")
+	if err != nil {
+		st = status.Convert(err)
+	}
+	a.grpcStatus = st
+	a.grpcTrailers = trailer
+	a.lastRequest = nil
+	a.lastCode = 0
+	a.lastStatus = st.Code().String()
+	a.lastErrors = []byte("")
+	a.lastHeaders = map[string]string{}
+	for k, v := range header {
+		a.lastHeaders[k] = v[0]
+	}
+	a.lastBody, err = grpcResponseJSON(st, responses, md.IsServerStreaming())
+	log.Trace().Str("status", st.Code().String()).Str("message", st.Message()).Msg(string(a.lastBody))
+	return err
+}
+
+// grpcResponseJSON encodes the response messages, or the status if the call
+// failed.
+func grpcResponseJSON(st *status.Status, responses []proto.Message, streaming bool) ([]byte, error) {
+	if st.Code() != codes.OK && len(responses) == 0 {
+		return json.Marshal(map[string]interface{}{"code": st.Code().String(), "message": st.Message()})
+	}
+	marshaler := &jsonpb.Marshaler{EmitDefaults: true}
+	var messages []json.RawMessage
+	for _, resp := range responses {
+		dm, ok := resp.(*dynamic.Message)
+		if !ok {
+			return nil, fmt.Errorf("Unexpected gRPC response type %T", resp)
+		}
+		content, err := dm.MarshalJSONPB(marshaler)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, content)
+	}
+	if !streaming {
+		return messages[0], nil
+	}
+	if messages == nil {
+		messages = []json.RawMessage{}
+	}
+	return json.Marshal(messages)
+}
+
+// grpcConn connects in plaintext unless GRPC_TLS is set, then with the same
+// TLS options as HTTP requests.
+func (a *apiFeature) grpcConn(ctx context.Context, address string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if useTLS, _ := strconv.ParseBool(a.setting("GRPC_TLS")); useTLS {
+		config, err := a.transportOptions().tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(config)
+	}
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to gRPC server %s: %s", address, err)
+	}
+	return conn, nil
+}
+
+// grpcMethod resolves the method from GRPC_PROTO_FILES (relative to
+// GRPC_IMPORT_PATHS) if set, otherwise through server reflection.
+func (a *apiFeature) grpcMethod(ctx context.Context, conn *grpc.ClientConn, service, method string) (*desc.MethodDescriptor, error) {
+	var sd *desc.ServiceDescriptor
+	if files := splitList(a.setting("GRPC_PROTO_FILES")); len(files) > 0 {
+		parser := protoparse.Parser{ImportPaths: splitList(a.setting("GRPC_IMPORT_PATHS"))}
+		fds, err := parser.ParseFiles(files...)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse proto files: %s", err)
+		}
+		for _, fd := range fds {
+			if sd = fd.FindService(service); sd != nil {
+				break
+			}
+		}
+		if sd == nil {
+			return nil, fmt.Errorf("No gRPC service %s in %s", service, strings.Join(files, ", "))
+		}
+	} else {
+		client := grpcreflect.NewClient(ctx, rpb.NewServerReflectionClient(conn))
+		defer client.Reset()
+		var err error
+		if sd, err = client.ResolveService(service); err != nil {
+			return nil, fmt.Errorf("Cannot resolve gRPC service %s by reflection: %s", service, err)
+		}
+	}
+	md := sd.FindMethodByName(method)
+	if md == nil {
+		return nil, fmt.Errorf("No gRPC method %s in service %s", method, service)
+	}
+	return md, nil
+}
+
+func (a *apiFeature) iUseProtoFiles(files string) error {
+	a.memory["GRPC_PROTO_FILES"] = a.getParsed(files)
+	return nil
+}
+
+func (a *apiFeature) theGRPCStatusCodeShouldBe(expected string) error {
+	if a.grpcStatus == nil {
+		return fmt.Errorf("No gRPC call made")
+	}
+	var code codes.Code
+	if n, err := strconv.Atoi(expected); err == nil {
+		code = codes.Code(n)
+	} else if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(expected)))); err != nil {
+		return fmt.Errorf("Unknown gRPC status code %s", expected)
+	}
+	if actual := a.grpcStatus.Code(); actual != code {
+		return fmt.Errorf("expected gRPC status code to be: %s, but actual is: %s (%s)", code, actual, a.grpcStatus.Message())
+	}
+	return nil
+}
+
+func (a *apiFeature) theGRPCStatusMessageShouldBe(message string) error {
+	if a.grpcStatus == nil {
+		return fmt.Errorf("No gRPC call made")
+	}
+	message = a.getParsed(message)
+	if actual := a.grpcStatus.Message(); actual != message {
+		return fmt.Errorf("expected gRPC status message to be: %s, but actual is: %s", message, actual)
+	}
+	return nil
+}
+
+func (a *apiFeature) theGRPCTrailerShouldMatch(key, value string) error {
+	value = a.getParsed(value)
+	values := a.grpcTrailers.Get(key)
+	if len(values) == 0 {
+		return fmt.Errorf("expected gRPC trailer %s to be: %s, but found no such trailer", key, value)
+	}
+	if values[0] != value {
+		return fmt.Errorf("expected gRPC trailer %s to be: %s, but actual is: %s", key, value, values[0])
+	}
+	return nil
+}
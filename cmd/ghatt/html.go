@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog/log"
+)
+
+func (a *apiFeature) cssSelection(selector string) (*goquery.Selection, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(a.lastBody))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse HTML response: %s", err)
+	}
+	selection := doc.Find(a.getParsed(selector))
+	log.Trace().Str("selector", selector).Int("count", selection.Length()).Msg("CSS selection")
+	return selection, nil
+}
+
+// cssValue returns the trimmed text (or an attribute if given) of the first
+// element matching the selector.
+func (a *apiFeature) cssValue(selector, attribute string) (string, error) {
+	selection, err := a.cssSelection(selector)
+	if err != nil {
+		return "", err
+	}
+	if selection.Length() == 0 {
+		return "", fmt.Errorf("No elements for css=[%s]", selector)
+	}
+	first := selection.First()
+	if attribute == "" {
+		return strings.TrimSpace(first.Text()), nil
+	}
+	value, ok := first.Attr(attribute)
+	if !ok {
+		return "", fmt.Errorf("No attribute %s for css=[%s]", attribute, selector)
+	}
+	return value, nil
+}
+
+func (a *apiFeature) theResponseCssTextShouldBe(selector, value string) (err error) {
+	defer a.withCurl(&err)
+	return a.cssShouldBe(selector, "", value)
+}
+func (a *apiFeature) theResponseCssAttributeShouldBe(selector, attribute, value string) (err error) {
+	defer a.withCurl(&err)
+	return a.cssShouldBe(selector, attribute, value)
+}
+func (a *apiFeature) cssShouldBe(selector, attribute, value string) error {
+	value = a.getParsed(value)
+	actual, err := a.cssValue(selector, attribute)
+	if err != nil {
+		return err
+	}
+	if actual != value {
+		return fmt.Errorf("No match for value, expected=[%s] got=[%s] for css=[%s]", value, actual, selector)
+	}
+	return nil
+}
+
+func (a *apiFeature) theResponseCssShouldMatchElements(selector string, expected int) (err error) {
+	defer a.withCurl(&err)
+	selection, err := a.cssSelection(selector)
+	if err != nil {
+		return err
+	}
+	if selection.Length() != expected {
+		return fmt.Errorf("expected %d elements for css=[%s], but found %d", expected, selector, selection.Length())
+	}
+	return nil
+}
+
+func (a *apiFeature) iRememberResponseCssTextAs(selector, key string) error {
+	return a.rememberCss(selector, "", key)
+}
+func (a *apiFeature) iRememberResponseCssAttributeAs(selector, attribute, key string) error {
+	return a.rememberCss(selector, attribute, key)
+}
+func (a *apiFeature) rememberCss(selector, attribute, key string) error {
+	value, err := a.cssValue(selector, attribute)
+	if err != nil {
+		return err
+	}
+	a.memory[key] = value
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", value).Msg("Remembered")
+	return nil
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	postmanVariable = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+	identifier      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	jsAccessor      = `((?:\.[A-Za-z_][A-Za-z0-9_]*|\[\d+\])*)`
+	jsString        = `["']([^"']*)["']`
+
+	postmanJSONVar      = regexp.MustCompile(`(?:var|let|const)\s+([A-Za-z_]\w*)\s*=\s*(?:pm\.response\.json\(\)|JSON\.parse\(responseBody\))`)
+	postmanStatus       = regexp.MustCompile(`pm\.response\.to\.have\.status\((\d+)\)|pm\.expect\(pm\.response\.code\)\.to\.(?:eql|equal|eq|be\.equal)\((\d+)\)|responseCode\.code\s*===?\s*(\d+)`)
+	postmanHeader       = regexp.MustCompile(`pm\.response\.to\.have\.header\(` + jsString + `\s*,\s*` + jsString + `\)`)
+	postmanExpect       = regexp.MustCompile(`pm\.expect\(([A-Za-z_]\w*|pm\.response\.json\(\))` + jsAccessor + `\)\.to\.(?:eql|equal|eq|be\.eql|be\.equal|deep\.equal)\((.+?)\)\s*;?\s*$`)
+	postmanSet          = regexp.MustCompile(`(?:pm\.(?:environment|collectionVariables|globals|variables)\.set|postman\.set(?:Environment|Global)Variable)\(\s*` + jsString + `\s*,\s*([A-Za-z_]\w*|pm\.response\.json\(\))` + jsAccessor + `\s*\)`)
+	postmanAssertionAPI = regexp.MustCompile(`pm\.(?:expect|response\.to|environment\.set|collectionVariables\.set|globals\.set|variables\.set)|tests\[`)
+
+	// headers set by the HTTP client, or by the browser for HAR recordings
+	importSkippedHeaders = map[string]bool{
+		"host":                      true,
+		"content-length":            true,
+		"connection":                true,
+		"cookie":                    true,
+		"accept-encoding":           true,
+		"user-agent":                true,
+		"origin":                    true,
+		"referer":                   true,
+		"sec-fetch-dest":            true,
+		"sec-fetch-mode":            true,
+		"sec-fetch-site":            true,
+		"sec-fetch-user":            true,
+		"sec-ch-ua":                 true,
+		"sec-ch-ua-mobile":          true,
+		"sec-ch-ua-platform":        true,
+		"upgrade-insecure-requests": true,
+	}
+	importMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true}
+)
+
+// runImport implements "ghatt import postman|har file [--out features]".
+func runImport(args []string) int {
+	usage := "usage: ghatt import postman collection.json|har session.har [--out features]"
+	if len(args) == 0 || (args[0] != "postman" && args[0] != "har") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("import "+args[0], flag.ContinueOnError)
+	out := flags.String("out", "features", "output directory")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	// allow flags after the file name too
+	file := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return 2
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot read file")
+		return 1
+	}
+	var feature *importedFeature
+	if args[0] == "postman" {
+		feature, err = importPostman(content)
+	} else {
+		feature, err = importHAR(content, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("file", file).Msg("Cannot import")
+		return 1
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Error().Err(err).Msg("Cannot create output directory")
+		return 1
+	}
+	featureFile := filepath.Join(*out, strings.ToLower(snakeCase(feature.name))+".feature")
+	if err := ioutil.WriteFile(featureFile, []byte(feature.render(file)), 0644); err != nil {
+		log.Error().Err(err).Msg("Cannot write feature")
+		return 1
+	}
+	log.Info().Str("file", featureFile).Int("requests", len(feature.requests)).Msg("Imported")
+	return 0
+}
+
+type importedFeature struct {
+	name      string
+	endpoint  string
+	variables [][2]string
+	requests  []*importedRequest
+}
+
+type importedRequest struct {
+	name     string
+	method   string
+	url      string
+	headers  [][2]string
+	body     string
+	steps    []string
+	comments []string
+}
+
+// render writes a scenario per request. Variables set by one request are
+// remembered for the feature, so later scenarios can use them.
+func (f *importedFeature) render(source string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Feature: %s\n", f.name)
+	fmt.Fprintf(&b, "  Imported from %s, review the requests and extend the assertions.\n", source)
+	if f.endpoint != "" {
+		fmt.Fprintf(&b, "  Run with HTTP_ENDPOINT=%s\n", f.endpoint)
+	}
+	if len(f.variables) > 0 {
+		b.WriteString("\n  Background:\n")
+		step := "Given"
+		for _, v := range f.variables {
+			fmt.Fprintf(&b, "    %s I remember %q as %q\n", step, v[0], v[1])
+			step = "And"
+		}
+	}
+	for _, r := range f.requests {
+		fmt.Fprintf(&b, "\n  Scenario: %s\n", r.name)
+		for _, c := range r.comments {
+			fmt.Fprintf(&b, "    # %s\n", c)
+		}
+		step := "Given"
+		for _, h := range r.headers {
+			fmt.Fprintf(&b, "    %s I set HTTP header %q as %q\n", step, h[0], h[1])
+			step = "And"
+		}
+		if !importMethods[r.method] {
+			fmt.Fprintf(&b, "    # TODO unsupported method %s %s\n", r.method, r.url)
+			continue
+		}
+		if r.body == "" {
+			fmt.Fprintf(&b, "    When I send %q request to %q\n", r.method, r.url)
+		} else {
+			fmt.Fprintf(&b, "    When I send %q request to %q with data:\n      \"\"\"\n", r.method, r.url)
+			// gherkin unescapes \"\"\" in a docstring, a body containing """
+			// would end it
+			body := strings.Replace(r.body, `"""`, `\"\"\"`, -1)
+			for _, line := range strings.Split(body, "\n") {
+				fmt.Fprintf(&b, "      %s\n", line)
+			}
+			b.WriteString("      \"\"\"\n")
+		}
+		step = "Then"
+		for _, s := range r.steps {
+			fmt.Fprintf(&b, "    %s %s\n", step, s)
+			step = "And"
+		}
+	}
+	return b.String()
+}
+
+// addHeader keeps headers the steps can express, values with double quotes
+// are left as comments.
+func (r *importedRequest) addHeader(key, value string) {
+	if importSkippedHeaders[strings.ToLower(key)] || strings.HasPrefix(key, ":") {
+		return
+	}
+	for _, h := range r.headers {
+		if strings.EqualFold(h[0], key) {
+			return
+		}
+	}
+	if strings.Contains(key+value, `"`) {
+		r.comments = append(r.comments, fmt.Sprintf("TODO header %s: %s", key, value))
+		return
+	}
+	r.headers = append(r.headers, [2]string{key, value})
+}
+
+// relativeURL strips the endpoint from the URL, the first absolute URL seen
+// becomes the endpoint.
+func (f *importedFeature) relativeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+	origin := u.Scheme + "://" + u.Host
+	if f.endpoint == "" {
+		f.endpoint = origin
+	}
+	if origin != f.endpoint {
+		return raw
+	}
+	if path := strings.TrimPrefix(raw, origin); path != "" {
+		return path
+	}
+	return "/"
+}
+
+type postmanCollection struct {
+	Info struct {
+		Name string `json:"name"`
+	} `json:"info"`
+	Item     []postmanItem     `json:"item"`
+	Variable []postmanKeyValue `json:"variable"`
+	Auth     *postmanAuth      `json:"auth"`
+}
+
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item"`
+	Request *postmanRequest `json:"request"`
+	Event   []postmanEvent  `json:"event"`
+	Auth    *postmanAuth    `json:"auth"`
+}
+
+type postmanRequest struct {
+	Method string            `json:"method"`
+	URL    json.RawMessage   `json:"url"`
+	Header []postmanKeyValue `json:"header"`
+	Body   *postmanBody      `json:"body"`
+	Auth   *postmanAuth      `json:"auth"`
+}
+
+type postmanKeyValue struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	Disabled bool        `json:"disabled"`
+}
+
+type postmanBody struct {
+	Mode       string            `json:"mode"`
+	Raw        string            `json:"raw"`
+	URLEncoded []postmanKeyValue `json:"urlencoded"`
+	GraphQL    *struct {
+		Query     string `json:"query"`
+		Variables string `json:"variables"`
+	} `json:"graphql"`
+}
+
+type postmanAuth struct {
+	Type   string            `json:"type"`
+	Bearer []postmanKeyValue `json:"bearer"`
+}
+
+type postmanEvent struct {
+	Listen string `json:"listen"`
+	Script struct {
+		Exec json.RawMessage `json:"exec"`
+	} `json:"script"`
+}
+
+func importPostman(content []byte) (*importedFeature, error) {
+	var c postmanCollection
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, err
+	}
+	if c.Info.Name == "" {
+		return nil, fmt.Errorf("Not a Postman collection, no info.name")
+	}
+	f := &importedFeature{name: c.Info.Name}
+	var endpointVar string
+	var walk func(items []postmanItem, prefix string, auth *postmanAuth) error
+	walk = func(items []postmanItem, prefix string, auth *postmanAuth) error {
+		for _, item := range items {
+			itemAuth := auth
+			if item.Auth != nil {
+				itemAuth = item.Auth
+			}
+			if item.Request == nil {
+				if err := walk(item.Item, prefix+item.Name+" / ", itemAuth); err != nil {
+					return err
+				}
+				continue
+			}
+			r, v, err := f.postmanRequest(prefix+item.Name, item, itemAuth)
+			if err != nil {
+				return fmt.Errorf("%s: %s", item.Name, err)
+			}
+			if v != "" {
+				endpointVar = v
+			}
+			f.requests = append(f.requests, r)
+		}
+		return nil
+	}
+	if err := walk(c.Item, "", c.Auth); err != nil {
+		return nil, err
+	}
+	set := map[string]bool{}
+	for _, r := range f.requests {
+		for _, s := range r.steps {
+			if strings.HasPrefix(s, "I remember for feature ") {
+				set[strings.Split(s, `"`)[1]] = true
+			}
+		}
+	}
+	for _, v := range c.Variable {
+		value := fmt.Sprintf("%v", v.Value)
+		if v.Key == endpointVar {
+			f.endpoint = value
+			continue
+		}
+		// collection variables set by tests are remembered for the feature
+		if set[v.Key] || strings.Contains(value, `"`) {
+			continue
+		}
+		f.variables = append(f.variables, [2]string{v.Key, postmanTemplate(value)})
+	}
+	if endpointVar != "" && f.endpoint == "" {
+		f.endpoint = "{{" + endpointVar + "}}"
+	}
+	return f, nil
+}
+
+// postmanRequest converts a request, returning the variable its URL starts
+// with, which is taken as HTTP_ENDPOINT.
+func (f *importedFeature) postmanRequest(name string, item postmanItem, auth *postmanAuth) (*importedRequest, string, error) {
+	req := item.Request
+	r := &importedRequest{name: name, method: strings.ToUpper(req.Method)}
+	if r.method == "" {
+		r.method = "GET"
+	}
+	var raw string
+	if err := json.Unmarshal(req.URL, &raw); err != nil {
+		var u struct {
+			Raw string `json:"raw"`
+		}
+		if err := json.Unmarshal(req.URL, &u); err != nil {
+			return nil, "", err
+		}
+		raw = u.Raw
+	}
+	endpointVar := ""
+	if m := postmanVariable.FindStringSubmatchIndex(raw); m != nil && m[0] == 0 {
+		endpointVar = raw[m[2]:m[3]]
+		raw = raw[m[1]:]
+		if !strings.HasPrefix(raw, "/") {
+			raw = "/" + raw
+		}
+	} else {
+		raw = f.relativeURL(raw)
+	}
+	r.url = postmanTemplate(raw)
+
+	if req.Auth != nil {
+		auth = req.Auth
+	}
+	if auth != nil && auth.Type == "bearer" {
+		for _, kv := range auth.Bearer {
+			if kv.Key == "token" {
+				r.addHeader("Authorization", "Bearer "+postmanTemplate(fmt.Sprintf("%v", kv.Value)))
+			}
+		}
+	} else if auth != nil && auth.Type != "noauth" {
+		r.comments = append(r.comments, fmt.Sprintf("TODO %s authentication", auth.Type))
+	}
+	for _, h := range req.Header {
+		if !h.Disabled {
+			r.addHeader(h.Key, postmanTemplate(fmt.Sprintf("%v", h.Value)))
+		}
+	}
+	if body := req.Body; body != nil {
+		switch body.Mode {
+		case "raw":
+			r.body = postmanTemplate(body.Raw)
+		case "urlencoded":
+			var values []string
+			for _, kv := range body.URLEncoded {
+				if !kv.Disabled {
+					values = append(values, url.QueryEscape(kv.Key)+"="+url.QueryEscape(fmt.Sprintf("%v", kv.Value)))
+				}
+			}
+			r.body = postmanTemplate(strings.Join(values, "&"))
+			r.addHeader("Content-Type", "application/x-www-form-urlencoded")
+		case "graphql":
+			if body.GraphQL != nil {
+				var variables interface{}
+				json.Unmarshal([]byte(body.GraphQL.Variables), &variables)
+				content, _ := json.MarshalIndent(map[string]interface{}{"query": body.GraphQL.Query, "variables": variables}, "", "  ")
+				r.body = postmanTemplate(string(content))
+				r.addHeader("Content-Type", "application/json")
+			}
+		case "":
+		default:
+			r.comments = append(r.comments, fmt.Sprintf("TODO %s body", body.Mode))
+		}
+	}
+	for _, e := range item.Event {
+		if e.Listen == "test" {
+			r.convertPostmanTests(postmanScript(e.Script.Exec))
+		}
+	}
+	return r, endpointVar, nil
+}
+
+func postmanScript(exec json.RawMessage) []string {
+	var lines []string
+	if err := json.Unmarshal(exec, &lines); err != nil {
+		var script string
+		json.Unmarshal(exec, &script)
+		lines = strings.Split(script, "\n")
+	}
+	return lines
+}
+
+// convertPostmanTests turns recognised assertions into steps, the rest are
+// left as comments.
+func (r *importedRequest) convertPostmanTests(lines []string) {
+	jsonVars := map[string]bool{"pm.response.json()": true}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if m := postmanJSONVar.FindStringSubmatch(line); m != nil {
+			jsonVars[m[1]] = true
+			continue
+		}
+		if m := postmanStatus.FindStringSubmatch(line); m != nil {
+			r.steps = append(r.steps, "the response code should be "+m[1]+m[2]+m[3])
+			continue
+		}
+		if m := postmanHeader.FindStringSubmatch(line); m != nil {
+			r.steps = append(r.steps, fmt.Sprintf("the response header %q should match %q", m[1], m[2]))
+			continue
+		}
+		if m := postmanExpect.FindStringSubmatch(line); m != nil && jsonVars[m[1]] {
+			if step := jqAssertion(jqPath(m[2]), strings.TrimSpace(m[3])); step != "" {
+				r.steps = append(r.steps, step)
+				continue
+			}
+		}
+		if m := postmanSet.FindStringSubmatch(line); m != nil && jsonVars[m[2]] {
+			r.steps = append(r.steps,
+				fmt.Sprintf("I remember response jq %q as %q", jqPath(m[3]), m[1]),
+				fmt.Sprintf("I remember for feature %q as %q", m[1], "{{."+m[1]+"}}"))
+			continue
+		}
+		if postmanAssertionAPI.MatchString(line) {
+			r.comments = append(r.comments, "TODO Postman test: "+line)
+		}
+	}
+}
+
+// jqPath converts a JavaScript property accessor like .data.items[0].id.
+func jqPath(accessor string) string {
+	if accessor == "" {
+		return "."
+	}
+	return accessor
+}
+
+// jqAssertion returns a jq step for a JavaScript literal, or "" if the value
+// is not a simple literal.
+func jqAssertion(path, literal string) string {
+	switch {
+	case len(literal) >= 2 && (literal[0] == '"' || literal[0] == '\'') && literal[len(literal)-1] == literal[0]:
+		value := literal[1 : len(literal)-1]
+		if strings.ContainsAny(value, `"'`) {
+			return ""
+		}
+		return fmt.Sprintf("the response jq %q should match %q", path, value)
+	case literal == "true" || literal == "false":
+		return fmt.Sprintf("the response jq %q should match bool %q", path, literal)
+	}
+	if _, err := strconv.Atoi(literal); err == nil {
+		return fmt.Sprintf("the response jq %q should match number %q", path, literal)
+	}
+	if _, err := strconv.ParseFloat(literal, 64); err == nil {
+		return fmt.Sprintf("the response jq %q should match float %q", path, literal)
+	}
+	return ""
+}
+
+// postmanTemplate converts {{name}} variables into memory templates, other
+// braces are kept as literal text.
+func postmanTemplate(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range postmanVariable.FindAllStringSubmatchIndex(s, -1) {
+		literal := templateLiteral(s[last:m[0]])
+		// a brace right before the variable would open its action
+		if strings.HasSuffix(literal, "{") {
+			literal = literal[:len(literal)-1] + `{{"{"}}`
+		}
+		b.WriteString(literal)
+		name := s[m[2]:m[3]]
+		switch {
+		case name == "$guid" || name == "$randomUUID":
+			b.WriteString("{{betterguid}}")
+		case name == "$timestamp":
+			b.WriteString("{{(now).Unix}}")
+		case identifier.MatchString(name):
+			b.WriteString("{{." + name + "}}")
+		default:
+			b.WriteString("{{index . `" + name + "`}}")
+		}
+		last = m[1]
+	}
+	b.WriteString(templateLiteral(s[last:]))
+	return b.String()
+}
+
+// templateLiteral escapes {{ so text is not expanded as a memory template.
+func templateLiteral(s string) string {
+	return strings.Replace(s, "{{", `{{"{{"}}`, -1)
+}
+
+type harLog struct {
+	Log struct {
+		Entries []struct {
+			ResourceType string `json:"_resourceType"`
+			Request      struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// importHAR converts the API calls of a recording, skipping the static
+// resources browsers record.
+func importHAR(content []byte, name string) (*importedFeature, error) {
+	var har harLog
+	if err := json.Unmarshal(content, &har); err != nil {
+		return nil, err
+	}
+	f := &importedFeature{name: name}
+	for _, e := range har.Log.Entries {
+		if e.ResourceType != "" && e.ResourceType != "xhr" && e.ResourceType != "fetch" {
+			continue
+		}
+		path := f.relativeURL(e.Request.URL)
+		r := &importedRequest{name: e.Request.Method + " " + path, method: strings.ToUpper(e.Request.Method), url: templateLiteral(path)}
+		for _, h := range e.Request.Headers {
+			r.addHeader(h.Name, templateLiteral(h.Value))
+		}
+		if e.Request.PostData != nil {
+			r.body = templateLiteral(e.Request.PostData.Text)
+		}
+		if e.Response.Status > 0 {
+			r.steps = append(r.steps, fmt.Sprintf("the response code should be %d", e.Response.Status))
+		}
+		f.requests = append(f.requests, r)
+	}
+	if len(f.requests) == 0 {
+		return nil, fmt.Errorf("No requests found")
+	}
+	return f, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"text/template"
+
+	"github.com/cucumber/godog"
+)
+
+func TestConvertPostmanTests(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		steps    []string
+		comments []string
+	}{
+		{"status", []string{
+			`pm.test("ok", function () {`,
+			`    pm.response.to.have.status(200);`,
+			`});`,
+			`pm.expect(pm.response.code).to.eql(201);`,
+			`tests["legacy"] = responseCode.code === 204;`,
+		}, []string{
+			"the response code should be 200",
+			"the response code should be 201",
+			"the response code should be 204",
+		}, nil},
+		{"header", []string{`pm.response.to.have.header('Content-Type', "application/json");`},
+			[]string{`the response header "Content-Type" should match "application/json"`}, nil},
+		{"json accessors", []string{
+			`var jsonData = pm.response.json();`,
+			`pm.expect(jsonData.data.items[0].id).to.eql(42);`,
+			`pm.expect(jsonData.name).to.equal('John');`,
+			`pm.expect(jsonData.active).to.be.equal(true)`,
+			`pm.expect(jsonData.price).to.eql(9.5);`,
+			`pm.expect(pm.response.json().list[1]).to.eql("b");`,
+		}, []string{
+			`the response jq ".data.items[0].id" should match number "42"`,
+			`the response jq ".name" should match "John"`,
+			`the response jq ".active" should match bool "true"`,
+			`the response jq ".price" should match float "9.5"`,
+			`the response jq ".list[1]" should match "b"`,
+		}, nil},
+		{"legacy json variable", []string{
+			`const body = JSON.parse(responseBody);`,
+			`pm.expect(body.id).to.eql(1);`,
+		}, []string{`the response jq ".id" should match number "1"`}, nil},
+		{"set variables", []string{
+			`let json = pm.response.json();`,
+			`pm.environment.set("TOKEN", json.auth.token);`,
+			`postman.setGlobalVariable('USER_ID', json.users[0].id);`,
+		}, []string{
+			`I remember response jq ".auth.token" as "TOKEN"`,
+			`I remember for feature "TOKEN" as "{{.TOKEN}}"`,
+			`I remember response jq ".users[0].id" as "USER_ID"`,
+			`I remember for feature "USER_ID" as "{{.USER_ID}}"`,
+		}, nil},
+		{"unconverted assertions", []string{
+			`pm.expect(other.id).to.eql(1);`,
+			`pm.expect(pm.response.json()).to.eql({"a": 1});`,
+			`pm.expect(pm.response.json().name).to.eql("it's");`,
+			`pm.expect(pm.response.json().items).to.have.lengthOf(2);`,
+			`pm.expect(pm.response.json()["odd key"]).to.eql(1);`,
+			`console.log(pm.response.text());`,
+		}, nil, []string{
+			`TODO Postman test: pm.expect(other.id).to.eql(1);`,
+			`TODO Postman test: pm.expect(pm.response.json()).to.eql({"a": 1});`,
+			`TODO Postman test: pm.expect(pm.response.json().name).to.eql("it's");`,
+			`TODO Postman test: pm.expect(pm.response.json().items).to.have.lengthOf(2);`,
+			`TODO Postman test: pm.expect(pm.response.json()["odd key"]).to.eql(1);`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &importedRequest{}
+			r.convertPostmanTests(tt.lines)
+			if !reflect.DeepEqual(r.steps, tt.steps) {
+				t.Errorf("got steps\n%q\nwant\n%q", r.steps, tt.steps)
+			}
+			if !reflect.DeepEqual(r.comments, tt.comments) {
+				t.Errorf("got comments\n%q\nwant\n%q", r.comments, tt.comments)
+			}
+		})
+	}
+}
+
+func TestPostmanTemplate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/users/{{userId}}", "/users/{{.userId}}"},
+		{"{{ base-url }}/x", "{{index . `base-url`}}/x"},
+		{"{{$guid}}", "{{betterguid}}"},
+		{`{"a": {{count}}}`, `{"a": {{.count}}}`},
+		{`{{{id}}}`, `{{"{"}}{{.id}}}`},
+		{`{{ {"a": 1} }}`, `{{"{{"}} {"a": 1} }}`},
+		{`[{{"a": "{{name}}"}}]`, `[{{"{{"}}"a": "{{.name}}"}}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := postmanTemplate(tt.in); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestImportedBodyIsSentVerbatim runs an imported request whose body holds a
+// docstring separator and template braces.
+func TestImportedBodyIsSentVerbatim(t *testing.T) {
+	body := "{\"doc\": \"\"\"\"\"\",\n \"tpl\": \"{{.X}}\", \"raw\": {{1}}}"
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	funcMap = template.FuncMap{}
+	defer func() { funcMap = nil }()
+
+	har := `{"log": {"entries": [{"request": {"method": "POST", "url": "` + srv.URL + `/items",
+		"postData": {"mimeType": "application/json", "text": ` + strconv.Quote(body) + `}},
+		"response": {"status": 200}}]}}`
+	f, err := importHAR([]byte(har), "Imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.variables = append(f.variables, [2]string{"HTTP_ENDPOINT", f.endpoint})
+	var output bytes.Buffer
+	status := godog.TestSuite{
+		ScenarioInitializer: InitializeScenario,
+		Options: &godog.Options{
+			Format:          "progress",
+			Output:          &output,
+			Strict:          true,
+			FeatureContents: []godog.Feature{{Name: "imported.feature", Contents: []byte(f.render("test.har"))}},
+		},
+	}.Run()
+	if status != 0 {
+		t.Fatalf("got status %d, want 0\n%s", status, output.String())
+	}
+	if string(received) != body {
+		t.Errorf("got body %s, want %s", received, body)
+	}
+}
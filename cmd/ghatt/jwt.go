@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var jwtEncoding = base64.RawURLEncoding
+
+// numeric date claims are sent as numbers even if templated as strings
+var jwtNumericClaims = []string{"exp", "nbf", "iat"}
+
+func jwtHash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("Unsupported JWT algorithm %s", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("Unsupported JWT algorithm %s", alg)
+}
+
+func hashed(h crypto.Hash, data []byte) []byte {
+	switch h {
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
+
+// JWT is the "jwt" template function. The key is a HMAC secret for HS*
+// algorithms and a PEM private key (or a path to one) for RS* and ES*.
+func JWT(alg, key string, claims map[string]interface{}) (string, error) {
+	for _, c := range jwtNumericClaims {
+		if s, ok := claims[c].(string); ok {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				claims[c] = n
+			}
+		}
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	signature, err := jwtSign(alg, key, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + jwtEncoding.EncodeToString(signature), nil
+}
+
+func jwtSign(alg, key string, input []byte) ([]byte, error) {
+	h, err := jwtHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(h.New, []byte(key))
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case "RS":
+		k, err := parsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a RSA private key", alg)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, rk, h, hashed(h, input))
+	case "ES":
+		k, err := parsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		ek, ok := k.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an EC private key", alg)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, ek, hashed(h, input))
+		if err != nil {
+			return nil, err
+		}
+		size := (ek.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[size-len(rb):size], rb)
+		copy(signature[2*size-len(sb):], sb)
+		return signature, nil
+	}
+	return nil, fmt.Errorf("Unsupported JWT algorithm %s", alg)
+}
+
+func jwtVerify(alg, key string, input, signature []byte) error {
+	h, err := jwtHash(alg)
+	if err != nil {
+		return err
+	}
+	switch alg[:2] {
+	case "HS":
+		mac := hmac.New(h.New, []byte(key))
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("Invalid JWT signature")
+		}
+		return nil
+	case "RS":
+		k, err := parsePublicKey(key)
+		if err != nil {
+			return err
+		}
+		rk, ok := k.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs a RSA public key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rk, h, hashed(h, input), signature); err != nil {
+			return errors.New("Invalid JWT signature")
+		}
+		return nil
+	case "ES":
+		k, err := parsePublicKey(key)
+		if err != nil {
+			return err
+		}
+		ek, ok := k.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an EC public key", alg)
+		}
+		size := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ek, hashed(h, input), r, s) {
+			return errors.New("Invalid JWT signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported JWT algorithm %s", alg)
+}
+
+// pemBlock decodes a PEM key given either inline or as a file path.
+func pemBlock(key string) (*pem.Block, error) {
+	content := []byte(key)
+	if !strings.Contains(key, "-----BEGIN") {
+		var err error
+		if content, err = ioutil.ReadFile(key); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("Cannot decode PEM key")
+	}
+	return block, nil
+}
+
+func parsePrivateKey(key string) (interface{}, error) {
+	block, err := pemBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+func parsePublicKey(key string) (interface{}, error) {
+	block, err := pemBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+type jwtToken struct {
+	header    map[string]interface{}
+	claims    map[string]interface{}
+	input     []byte
+	signature []byte
+}
+
+func parseJWT(token string) (*jwtToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Not a JWT: %s", token)
+	}
+	t := &jwtToken{input: []byte(parts[0] + "." + parts[1])}
+	for i, v := range []*map[string]interface{}{&t.header, &t.claims} {
+		data, err := jwtEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decode JWT: %s", err)
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(v); err != nil {
+			return nil, fmt.Errorf("Cannot decode JWT: %s", err)
+		}
+	}
+	var err error
+	if t.signature, err = jwtEncoding.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("Cannot decode JWT signature: %s", err)
+	}
+	return t, nil
+}
+
+func (t *jwtToken) claim(name string) (string, bool) {
+	v, ok := t.claims[name]
+	if !ok {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	b, _ := json.Marshal(v)
+	return string(b), true
+}
+
+func (t *jwtToken) expiresAt() (time.Time, error) {
+	exp, ok := t.claims["exp"].(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("JWT has no numeric exp claim")
+	}
+	seconds, err := exp.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(seconds), 0), nil
+}
+
+func (a *apiFeature) responseJWT(path string) (*jwtToken, error) {
+	var v interface{}
+	if err := a.unmarshalResponse(&v); err != nil {
+		return nil, err
+	}
+	res, err := jqLastValue(a.getParsed(path), v)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := res.(string)
+	if !ok {
+		return nil, fmt.Errorf("Expected a JWT string for path=[%s], got=[%v]", path, res)
+	}
+	return parseJWT(strings.TrimPrefix(token, "Bearer "))
+}
+
+func (a *apiFeature) theResponseJqShouldBeAJWTWithClaimEqualTo(path, name, value string) (err error) {
+	defer a.withCurl(&err)
+	token, err := a.responseJWT(path)
+	if err != nil {
+		return err
+	}
+	value = a.getParsed(value)
+	actual, ok := token.claim(name)
+	if !ok {
+		return fmt.Errorf("No claim %s in JWT for path=[%s]", name, path)
+	}
+	if actual != value {
+		return fmt.Errorf("No match for claim %s, expected=[%s] got=[%s] for path=[%s]", name, value, actual, path)
+	}
+	return nil
+}
+
+func (a *apiFeature) theResponseJqShouldBeAJWTSignedWithUsing(path, alg, key string) (err error) {
+	defer a.withCurl(&err)
+	token, err := a.responseJWT(path)
+	if err != nil {
+		return err
+	}
+	if actual, _ := token.header["alg"].(string); actual != alg {
+		return fmt.Errorf("Expected JWT signed with %s, got %s for path=[%s]", alg, actual, path)
+	}
+	return jwtVerify(alg, a.getParsed(key), token.input, token.signature)
+}
+
+func (a *apiFeature) theResponseJqShouldBeAJWTExpiringAfter(path, duration string) (err error) {
+	defer a.withCurl(&err)
+	token, err := a.responseJWT(path)
+	if err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	exp, err := token.expiresAt()
+	if err != nil {
+		return err
+	}
+	if !exp.After(time.Now().Add(d)) {
+		return fmt.Errorf("Expected JWT to expire after %s, but it expires at %s", duration, exp.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (a *apiFeature) theResponseJqShouldBeAnExpiredJWT(path string) (err error) {
+	defer a.withCurl(&err)
+	token, err := a.responseJWT(path)
+	if err != nil {
+		return err
+	}
+	exp, err := token.expiresAt()
+	if err != nil {
+		return err
+	}
+	if exp.After(time.Now()) {
+		return fmt.Errorf("Expected JWT to be expired, but it expires at %s", exp.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (a *apiFeature) iRememberJWTClaimFromResponseJqAs(name, path, key string) error {
+	token, err := a.responseJWT(path)
+	if err != nil {
+		return err
+	}
+	value, ok := token.claim(name)
+	if !ok {
+		return fmt.Errorf("No claim %s in JWT for path=[%s]", name, path)
+	}
+	a.memory[key] = value
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", value).Msg("Remembered")
+	return nil
+}
+
+// Dict is the "dict" template function building a map from key value pairs.
+func Dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict needs an even number of arguments")
+	}
+	m := map[string]interface{}{}
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// ReadFile is the "file" template function.
+func ReadFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	return string(content), err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/cucumber/godog"
+)
+
+func rsaKeyPair(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+}
+
+func TestJWTRoundTrip(t *testing.T) {
+	private, public := rsaKeyPair(t)
+	otherPrivate, otherPublic := rsaKeyPair(t)
+	tests := []struct {
+		name       string
+		alg        string
+		signKey    string
+		verifyKey  string
+		wantVerify bool
+	}{
+		{"HS256", "HS256", "secret", "secret", true},
+		{"HS256 wrong secret", "HS256", "secret", "other", false},
+		{"RS256", "RS256", private, public, true},
+		{"RS256 other key", "RS256", otherPrivate, public, false},
+		{"RS256 other public key", "RS256", private, otherPublic, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := JWT(tt.alg, tt.signKey, map[string]interface{}{"sub": "u1", "exp": "1700000000"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := parseJWT(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alg := parsed.header["alg"]; alg != tt.alg {
+				t.Errorf("got alg %v, want %s", alg, tt.alg)
+			}
+			if sub, _ := parsed.claim("sub"); sub != "u1" {
+				t.Errorf("got sub %s, want u1", sub)
+			}
+			if exp, _ := parsed.claim("exp"); exp != "1700000000" {
+				t.Errorf("got exp %s, want the number 1700000000", exp)
+			}
+			err = jwtVerify(tt.alg, tt.verifyKey, parsed.input, parsed.signature)
+			if (err == nil) != tt.wantVerify {
+				t.Errorf("got verify error %v, want valid %v", err, tt.wantVerify)
+			}
+		})
+	}
+}
+
+func TestJWTNumericClaims(t *testing.T) {
+	token, err := JWT("HS256", "secret", map[string]interface{}{"exp": "1700000000", "nbf": "1600000000", "iat": "now", "sub": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := jwtEncoding.DecodeString(strings.Split(token, ".")[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"exp":1700000000,"iat":"now","nbf":1600000000,"sub":"42"}`; string(payload) != want {
+		t.Errorf("got claims %s, want %s", payload, want)
+	}
+}
+
+func TestJWTExpirySteps(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		duration string
+		expiring bool
+		expired  bool
+	}{
+		{"valid for an hour", map[string]interface{}{"exp": now.Add(time.Hour).Unix(), "nbf": now.Unix()}, "50m", true, false},
+		{"valid for minutes", map[string]interface{}{"exp": now.Add(10 * time.Minute).Unix()}, "50m", false, false},
+		{"expired", map[string]interface{}{"exp": now.Add(-time.Hour).Unix(), "nbf": now.Add(-2 * time.Hour).Unix()}, "0s", false, true},
+		{"exp as string", map[string]interface{}{"exp": strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}, "0s", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := JWT("HS256", "secret", tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := json.Marshal(map[string]string{"token": token})
+			a := &apiFeature{lastBody: body}
+			if err := a.theResponseJqShouldBeAJWTExpiringAfter(".token", tt.duration); (err == nil) != tt.expiring {
+				t.Errorf("got expiring after %s error %v, want passing %v", tt.duration, err, tt.expiring)
+			}
+			if err := a.theResponseJqShouldBeAnExpiredJWT(".token"); (err == nil) != tt.expired {
+				t.Errorf("got expired error %v, want passing %v", err, tt.expired)
+			}
+		})
+	}
+	a := &apiFeature{lastBody: []byte(`{"token": "` + mustJWT(t, map[string]interface{}{"sub": "u1"}) + `"}`)}
+	if err := a.theResponseJqShouldBeAnExpiredJWT(".token"); err == nil {
+		t.Error("got a JWT without exp claim expired, want an error")
+	}
+}
+
+func mustJWT(t *testing.T, claims map[string]interface{}) string {
+	token, err := JWT("HS256", "secret", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestJWTHeaderStep runs the JWT header example of the README, the server
+// answers 401 only to a valid token with an expired exp claim.
+func TestJWTHeaderStep(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := parseJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil || jwtVerify("HS256", "s3cret", token.input, token.signature) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if sub, _ := token.claim("sub"); sub != "u1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if exp, err := token.expiresAt(); err != nil || exp.After(time.Now()) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	funcMap = template.FuncMap{"jwt": JWT, "dict": Dict, "after": After}
+	defer func() { funcMap = nil }()
+
+	feature := "Feature: JWT\n" +
+		"  Scenario: Token with expired claims is rejected\n" +
+		"    Given I remember \"SECRET\" as \"s3cret\"\n" +
+		"    And I set HTTP header \"Authorization\" as \"Bearer {{jwt `HS256` .SECRET (dict `sub` `u1` `exp` (after `-1h` `unix`))}}\"\n" +
+		"    When I send \"GET\" request to \"" + srv.URL + "/me\"\n" +
+		"    Then the response code should be 401\n"
+	var output bytes.Buffer
+	status := godog.TestSuite{
+		ScenarioInitializer: InitializeScenario,
+		Options: &godog.Options{
+			Format:          "progress",
+			Output:          &output,
+			Strict:          true,
+			FeatureContents: []godog.Feature{{Name: "jwt.feature", Contents: []byte(feature)}},
+		},
+	}.Run()
+	if status != 0 {
+		t.Errorf("got status %d, want 0\n%s", status, output.String())
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tokens obtained from token endpoints are refreshed this long before expiry
+const oauth2ExpirySkew = 30 * time.Second
+
+type oauth2Config struct {
+	Grant        string `json:"-"`
+	TokenURL     string `json:"token_url"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Scope        string `json:"scope"`
+	Audience     string `json:"audience"`
+	AuthStyle    string `json:"auth_style"` // "header" (default) or "body"
+}
+
+type oauth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	expiresAt    time.Time
+}
+
+var oauth2Tokens = struct {
+	sync.Mutex
+	tokens map[string]*oauth2Token
+}{tokens: map[string]*oauth2Token{}}
+
+func (c *oauth2Config) cacheKey() string {
+	return strings.Join([]string{c.Grant, c.TokenURL, c.ClientID, c.Username, c.Scope, c.Audience}, "\x00")
+}
+
+func (a *apiFeature) iAuthenticateWithOAuth2ClientCredentialsUsing(key string) error {
+	return a.authenticateWithOAuth2("client_credentials", key)
+}
+func (a *apiFeature) iAuthenticateWithOAuth2PasswordGrantUsing(key string) error {
+	return a.authenticateWithOAuth2("password", key)
+}
+
+// authenticateWithOAuth2 reads a json config from memory, obtains a token
+// (cached across scenarios until it expires) and sets the Authorization header.
+func (a *apiFeature) authenticateWithOAuth2(grant, key string) error {
+	raw, ok := a.memory[key]
+	if !ok {
+		return fmt.Errorf("No OAuth2 config %s defined. Please set memory with a value.", key)
+	}
+	config := &oauth2Config{Grant: grant}
+	if err := json.Unmarshal([]byte(a.getParsed(fmt.Sprintf("%v", raw))), config); err != nil {
+		return fmt.Errorf("Cannot parse OAuth2 config %s: %s", key, err)
+	}
+	if config.TokenURL == "" {
+		return fmt.Errorf("No token_url in OAuth2 config %s", key)
+	}
+	a.oauth2 = config
+	return a.applyOAuth2Token(false)
+}
+
+// applyOAuth2Token requests tokens with the transport options of the
+// scenario, so CA bundles, proxies and resolve overrides apply to them too.
+func (a *apiFeature) applyOAuth2Token(refresh bool) error {
+	t, err := a.transportOptions().transport()
+	if err != nil {
+		return err
+	}
+	token, err := oauth2TokenFor(&http.Client{Transport: t}, a.oauth2, refresh)
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	a.headers["Authorization"] = tokenType + " " + token.AccessToken
+	a.memory["OAUTH2_ACCESS_TOKEN"] = token.AccessToken
+	return nil
+}
+
+func oauth2TokenFor(client *http.Client, config *oauth2Config, refresh bool) (*oauth2Token, error) {
+	oauth2Tokens.Lock()
+	defer oauth2Tokens.Unlock()
+	key := config.cacheKey()
+	cached, ok := oauth2Tokens.tokens[key]
+	if ok && !refresh && time.Now().Before(cached.expiresAt) {
+		log.Trace().Str("token_url", config.TokenURL).Msg("Using cached OAuth2 token")
+		return cached, nil
+	}
+	var token *oauth2Token
+	var err error
+	if ok && cached.RefreshToken != "" {
+		token, err = requestOAuth2Token(client, config, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {cached.RefreshToken},
+		})
+		if err != nil {
+			log.Debug().Err(err).Msg("Cannot refresh OAuth2 token, requesting a new one")
+		}
+	}
+	if token == nil {
+		form := url.Values{"grant_type": {config.Grant}}
+		if config.Grant == "password" {
+			form.Set("username", config.Username)
+			form.Set("password", config.Password)
+		}
+		if token, err = requestOAuth2Token(client, config, form); err != nil {
+			delete(oauth2Tokens.tokens, key)
+			return nil, err
+		}
+	}
+	oauth2Tokens.tokens[key] = token
+	return token, nil
+}
+
+func requestOAuth2Token(client *http.Client, config *oauth2Config, form url.Values) (*oauth2Token, error) {
+	if config.Scope != "" {
+		form.Set("scope", config.Scope)
+	}
+	if config.Audience != "" {
+		form.Set("audience", config.Audience)
+	}
+	if config.AuthStyle == "body" {
+		form.Set("client_id", config.ClientID)
+		form.Set("client_secret", config.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.AuthStyle != "body" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+	log.Trace().Str("token_url", config.TokenURL).Str("grant_type", form.Get("grant_type")).Msg("Requesting OAuth2 token")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cannot get OAuth2 token: code=%d status=%s body=%s", resp.StatusCode, resp.Status, body)
+	}
+	token := &oauth2Token{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("Cannot parse OAuth2 token response: %s", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("No access_token in OAuth2 token response: %s", body)
+	}
+	if token.ExpiresIn > 0 {
+		token.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - oauth2ExpirySkew)
+	} else {
+		token.expiresAt = time.Now().Add(time.Hour)
+	}
+	return token, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// tokenServer issues the access tokens t1, t2, ... over TLS, so requests only
+// succeed with the transport options of the scenario.
+type tokenServer struct {
+	*httptest.Server
+	expiresIn int
+	mutex     sync.Mutex
+	grants    []string
+}
+
+func newTokenServer(expiresIn int) *tokenServer {
+	s := &tokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id == "" || secret != "secret" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		s.mutex.Lock()
+		s.grants = append(s.grants, r.FormValue("grant_type"))
+		n := len(s.grants)
+		s.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"t%d","token_type":"bearer","expires_in":%d,"refresh_token":"r%d"}`, n, s.expiresIn, n)
+	}))
+	return s
+}
+
+func (s *tokenServer) apiFeature(clientID string) *apiFeature {
+	return &apiFeature{
+		memory: map[string]interface{}{
+			"TLS_INSECURE": "true",
+			"OAUTH2":       fmt.Sprintf(`{"token_url": "%s/token", "client_id": "%s", "client_secret": "secret"}`, s.URL, clientID),
+		},
+		headers:      map[string]string{},
+		variables:    map[string]interface{}{},
+		maxRedirects: -1,
+	}
+}
+
+func TestOAuth2TokenCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		grants    []string
+		header    string
+	}{
+		{"cached until expiry", 3600, []string{"client_credentials"}, "Bearer t1"},
+		{"no expiry cached", 0, []string{"client_credentials"}, "Bearer t1"},
+		{"expired within skew", int(oauth2ExpirySkew.Seconds()), []string{"client_credentials", "refresh_token"}, "Bearer t2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTokenServer(tt.expiresIn)
+			defer s.Close()
+			for i := 0; i < 2; i++ {
+				a := s.apiFeature(tt.name)
+				if err := a.iAuthenticateWithOAuth2ClientCredentialsUsing("OAUTH2"); err != nil {
+					t.Fatal(err)
+				}
+				if i == 1 && a.headers["Authorization"] != tt.header {
+					t.Errorf("got Authorization %q, want %q", a.headers["Authorization"], tt.header)
+				}
+			}
+			if !reflect.DeepEqual(s.grants, tt.grants) {
+				t.Errorf("got grants %v, want %v", s.grants, tt.grants)
+			}
+		})
+	}
+}
+
+func TestOAuth2RefreshOn401(t *testing.T) {
+	s := newTokenServer(3600)
+	defer s.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			http.Error(w, "expired token", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer api.Close()
+
+	a := s.apiFeature("refresh on 401")
+	a.memory["HTTP_ENDPOINT"] = api.URL
+	if err := a.iAuthenticateWithOAuth2ClientCredentialsUsing("OAUTH2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.sendRequest("GET", "/users", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if a.lastCode != http.StatusOK || string(a.lastBody) != "ok" {
+		t.Errorf("got code=%d body=%s, want 200 ok", a.lastCode, a.lastBody)
+	}
+	if want := []string{"client_credentials", "refresh_token"}; !reflect.DeepEqual(s.grants, want) {
+		t.Errorf("got grants %v, want %v", s.grants, want)
+	}
+	if a.memory["OAUTH2_ACCESS_TOKEN"] != "t2" {
+		t.Errorf("got OAUTH2_ACCESS_TOKEN %v, want t2", a.memory["OAUTH2_ACCESS_TOKEN"])
+	}
+}
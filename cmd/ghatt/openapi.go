@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	openapiSpec    string
+	openapiMode    string
+	openapiDoc     *openapi3.T
+	openapiRouters = map[string]routers.Router{}
+	openapiMutex   sync.Mutex
+)
+
+// loadOpenAPI loads and validates the --openapi spec, if given.
+func loadOpenAPI() error {
+	if openapiSpec == "" {
+		return nil
+	}
+	switch openapiMode {
+	case "", "fail", "warn":
+	default:
+		return fmt.Errorf("Unsupported OpenAPI mode %s, expected fail or warn", openapiMode)
+	}
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	doc, err := loader.LoadFromFile(openapiSpec)
+	if err != nil {
+		return fmt.Errorf("Cannot load OpenAPI spec %s: %s", openapiSpec, err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return fmt.Errorf("Invalid OpenAPI spec %s: %s", openapiSpec, err)
+	}
+	// keep violations to one line per error, without the schema dump
+	openapi3.SchemaErrorDetailsDisabled = true
+	openapiDoc = doc
+	log.Debug().Str("spec", openapiSpec).Str("mode", openapiMode).Msg("OpenAPI validation enabled")
+	return nil
+}
+
+// openapiRouter matches requests against the spec servers, with
+// HTTP_ENDPOINT added first so specs need not list the tested server.
+func (a *apiFeature) openapiRouter() (routers.Router, error) {
+	endpoint := a.setting("HTTP_ENDPOINT")
+	openapiMutex.Lock()
+	defer openapiMutex.Unlock()
+	if router, ok := openapiRouters[endpoint]; ok {
+		return router, nil
+	}
+	doc := *openapiDoc
+	if endpoint != "" {
+		doc.Servers = append(openapi3.Servers{{URL: endpoint}}, openapiDoc.Servers...)
+	}
+	router, err := gorillamux.NewRouter(&doc)
+	if err != nil {
+		return nil, err
+	}
+	openapiRouters[endpoint] = router
+	return router, nil
+}
+
+// validateOpenAPI checks the last request and response against the operation
+// matching the request.
+func (a *apiFeature) validateOpenAPI() error {
+	if openapiDoc == nil {
+		return fmt.Errorf("No OpenAPI spec given, use --openapi")
+	}
+	if a.lastRequest == nil {
+		return fmt.Errorf("No request sent")
+	}
+	router, err := a.openapiRouter()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(a.lastRequest.Method, a.lastRequest.URL.String(), bytes.NewReader([]byte(a.lastRequestBody)))
+	if err != nil {
+		return err
+	}
+	req.Header = a.lastRequest.Header.Clone()
+	route, pathParams, err := router.FindRoute(req)
+	if err != nil {
+		return fmt.Errorf("No OpenAPI operation for %s %s: %s", req.Method, req.URL.Path, err)
+	}
+	options := &openapi3filter.Options{
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+		MultiError:            true,
+	}
+	requestInput := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route:      route,
+		Options:    options,
+	}
+	ctx := context.Background()
+	if err := openapi3filter.ValidateRequest(ctx, requestInput); err != nil {
+		return fmt.Errorf("OpenAPI request violation for %s %s: %s", req.Method, route.Path, err)
+	}
+	header := http.Header{}
+	for k, v := range a.lastHeaders {
+		header.Set(k, v)
+	}
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: requestInput,
+		Status:                 a.lastCode,
+		Header:                 header,
+		Body:                   ioutil.NopCloser(bytes.NewReader(a.lastBody)),
+		Options:                options,
+	}
+	if err := openapi3filter.ValidateResponse(ctx, responseInput); err != nil {
+		return fmt.Errorf("OpenAPI response violation for %s %s code=%d: %s", req.Method, route.Path, a.lastCode, err)
+	}
+	return nil
+}
+
+// sendCheckedRequest sends a request of the send steps and checks it against
+// the spec. Reset and GraphQL requests use sendRequest and are not checked.
+func (a *apiFeature) sendCheckedRequest(method, path string, body string, templated bool) error {
+	if err := a.sendRequest(method, path, body, templated); err != nil {
+		return err
+	}
+	return a.checkOpenAPI()
+}
+
+// checkOpenAPI validates the response when --openapi is given, failing the
+// step or only warning depending on --openapi-mode.
+func (a *apiFeature) checkOpenAPI() error {
+	if openapiDoc == nil {
+		return nil
+	}
+	err := a.validateOpenAPI()
+	if err != nil && openapiMode == "warn" {
+		log.Warn().Err(err).Msg("OpenAPI contract")
+		return nil
+	}
+	return err
+}
+
+func (a *apiFeature) theResponseShouldConformToTheOpenAPISpec() (err error) {
+	defer a.withCurl(&err)
+	return a.validateOpenAPI()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// messageQueue collects messages received in the background (event stream
+// events, websocket messages) for steps waiting on them.
+type messageQueue struct {
+	sync.Mutex
+	name     string
+	messages []interface{}
+	err      error
+	closed   bool
+	updated  chan struct{} // closed and replaced on every change
+}
+
+func newMessageQueue(name string) *messageQueue {
+	return &messageQueue{name: name, updated: make(chan struct{})}
+}
+
+func (q *messageQueue) add(m interface{}) {
+	q.Lock()
+	defer q.Unlock()
+	q.messages = append(q.messages, m)
+	q.notify()
+}
+
+// finish marks the queue closed, keeping the first error it was closed with.
+func (q *messageQueue) finish(err error) {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return
+	}
+	q.err = err
+	q.closed = true
+	q.notify()
+}
+
+// notify must be called with the lock held.
+func (q *messageQueue) notify() {
+	close(q.updated)
+	q.updated = make(chan struct{})
+}
+
+// wait waits until a message not matched before satisfies match. Matched
+// messages are consumed, so repeated steps check successive messages.
+func (q *messageQueue) wait(timeout string, match func(interface{}) bool) (interface{}, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.After(d)
+	seen := 0
+	for {
+		q.Lock()
+		for i := seen; i < len(q.messages); i++ {
+			if match(q.messages[i]) {
+				m := q.messages[i]
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				q.Unlock()
+				return m, nil
+			}
+		}
+		seen = len(q.messages)
+		closed, closeErr, updated := q.closed, q.err, q.updated
+		q.Unlock()
+		if closed {
+			return nil, fmt.Errorf("%s closed before a matching message arrived: %v", q.name, closeErr)
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			return nil, fmt.Errorf("No matching message received from %s within %s", q.name, timeout)
+		}
+	}
+}
+
+// jqString runs jq on JSON data, strings are returned unquoted.
+func jqString(data []byte, path string) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", err
+	}
+	res, err := jqLastValue(path, v)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := res.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(res)
+	return string(b), err
+}
+
+// waitClosed waits until the queue is closed and returns the error it was
+// closed with.
+func (q *messageQueue) waitClosed(timeout string) (error, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.After(d)
+	for {
+		q.Lock()
+		closed, closeErr, updated := q.closed, q.err, q.updated
+		q.Unlock()
+		if closed {
+			return closeErr, nil
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			return nil, fmt.Errorf("%s not closed within %s", q.name, timeout)
+		}
+	}
+}
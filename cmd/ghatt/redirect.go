@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cucumber/godog"
+	"github.com/rs/zerolog/log"
+)
+
+// default redirect limit of net/http
+const defaultMaxRedirects = 10
+
+type redirectHop struct {
+	status   int
+	location string
+	url      string
+}
+
+// checkRedirect records every redirect response and stops following them
+// after maxRedirects, returning the last redirect response instead.
+func (a *apiFeature) checkRedirect(req *http.Request, via []*http.Request) error {
+	max := a.maxRedirects
+	if max < 0 {
+		max = defaultMaxRedirects
+	}
+	useLast := a.maxRedirects >= 0 && len(via) > max
+	if req.Response != nil {
+		hop := redirectHop{
+			status:   req.Response.StatusCode,
+			location: req.Response.Header.Get("Location"),
+			url:      via[len(via)-1].URL.String(),
+		}
+		log.Trace().Int("status", hop.status).Str("location", hop.location).Msg("Redirect")
+		a.redirects = append(a.redirects, hop)
+		// a redirect response which is not followed is the final response,
+		// whose cookies doRequest records
+		if !useLast {
+			a.lastCookies = append(a.lastCookies, req.Response.Cookies()...)
+		}
+	}
+	if a.maxRedirects < 0 && len(via) >= max {
+		return fmt.Errorf("stopped after %d redirects", max)
+	}
+	if useLast {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+func (a *apiFeature) iDoNotFollowRedirects() error {
+	a.maxRedirects = 0
+	return nil
+}
+func (a *apiFeature) iFollowAtMostRedirects(max int) error {
+	a.maxRedirects = max
+	return nil
+}
+func (a *apiFeature) iFollowRedirects() error {
+	a.maxRedirects = -1
+	return nil
+}
+
+func (a *apiFeature) theRedirectChainShouldBe(table *godog.Table) (err error) {
+	defer a.withCurl(&err)
+	if len(table.Rows) == 0 {
+		return fmt.Errorf("Expected a header row with status and location columns")
+	}
+	columns := map[string]int{}
+	for i, cell := range table.Rows[0].Cells {
+		columns[cell.Value] = i
+	}
+	if len(table.Rows)-1 != len(a.redirects) {
+		return fmt.Errorf("Expected %d redirects, got %d: %s", len(table.Rows)-1, len(a.redirects), a.redirectChain())
+	}
+	for i, row := range table.Rows[1:] {
+		hop := a.redirects[i]
+		if c, ok := columns["status"]; ok && row.Cells[c].Value != strconv.Itoa(hop.status) {
+			return fmt.Errorf("No match for redirect %d status, expected=[%s] got=[%d] chain=%s", i+1, row.Cells[c].Value, hop.status, a.redirectChain())
+		}
+		if c, ok := columns["location"]; ok && a.getParsed(row.Cells[c].Value) != hop.location {
+			return fmt.Errorf("No match for redirect %d location, expected=[%s] got=[%s] chain=%s", i+1, a.getParsed(row.Cells[c].Value), hop.location, a.redirectChain())
+		}
+		if c, ok := columns["url"]; ok && a.getParsed(row.Cells[c].Value) != hop.url {
+			return fmt.Errorf("No match for redirect %d url, expected=[%s] got=[%s] chain=%s", i+1, a.getParsed(row.Cells[c].Value), hop.url, a.redirectChain())
+		}
+	}
+	return nil
+}
+
+func (a *apiFeature) redirectChain() string {
+	chain := "["
+	for i, hop := range a.redirects {
+		if i > 0 {
+			chain += ", "
+		}
+		chain += fmt.Sprintf("%d %s -> %s", hop.status, hop.url, hop.location)
+	}
+	return chain + "]"
+}
+
+func (a *apiFeature) iDumpRedirectChain() error {
+	for i, hop := range a.redirects {
+		log.Info().Int("n", i+1).Int("status", hop.status).Str("url", hop.url).Str("location", hop.location).Msg("Redirect dump")
+	}
+	return nil
+}
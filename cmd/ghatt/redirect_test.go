@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRedirectCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next := map[string]string{"/a": "/b", "/b": "/c"}
+		http.SetCookie(w, &http.Cookie{Name: r.URL.Path[1:], Value: "1"})
+		if location, ok := next[r.URL.Path]; ok {
+			http.Redirect(w, r, location, http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name         string
+		maxRedirects int
+		code         int
+		cookies      []string
+		redirects    int
+	}{
+		{"followed", -1, http.StatusOK, []string{"a", "b", "c"}, 2},
+		{"not followed", 0, http.StatusFound, []string{"a"}, 1},
+		{"followed once", 1, http.StatusFound, []string{"a", "b"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &apiFeature{
+				memory:       map[string]interface{}{},
+				headers:      map[string]string{},
+				variables:    map[string]interface{}{},
+				maxRedirects: tt.maxRedirects,
+			}
+			if err := api.sendRequest("GET", srv.URL+"/a", "", true); err != nil {
+				t.Fatal(err)
+			}
+			if api.lastCode != tt.code {
+				t.Errorf("got code %d, want %d", api.lastCode, tt.code)
+			}
+			var cookies []string
+			for _, c := range api.lastCookies {
+				cookies = append(cookies, c.Name)
+			}
+			if !reflect.DeepEqual(cookies, tt.cookies) {
+				t.Errorf("got cookies %v, want %v", cookies, tt.cookies)
+			}
+			if len(api.redirects) != tt.redirects {
+				t.Errorf("got %d redirects, want %d", len(api.redirects), tt.redirects)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/cucumber/godog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	scopeDefault  = "default"
+	scopeSuite    = "suite"
+	scopeFeature  = "feature"
+	scopeScenario = "scenario"
+)
+
+// scopedMemory is memory shared between scenarios, safe for concurrent use.
+type scopedMemory struct {
+	sync.RWMutex
+	values map[string]interface{}
+}
+
+var (
+	suiteMemory     = newScopedMemory()
+	featureMemories = struct {
+		sync.Mutex
+		features map[string]*scopedMemory
+	}{features: map[string]*scopedMemory{}}
+)
+
+func newScopedMemory() *scopedMemory {
+	return &scopedMemory{values: map[string]interface{}{}}
+}
+
+func (m *scopedMemory) set(key string, value interface{}) {
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] = value
+}
+
+func (m *scopedMemory) unset(key string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.values, key)
+}
+
+func (m *scopedMemory) copyTo(memory map[string]interface{}, scopes map[string]string, scope string) {
+	m.RLock()
+	defer m.RUnlock()
+	for k, v := range m.values {
+		memory[k] = v
+		scopes[k] = scope
+	}
+}
+
+// featureMemory returns memory shared by scenarios of the given feature file.
+func featureMemory(uri string) *scopedMemory {
+	featureMemories.Lock()
+	defer featureMemories.Unlock()
+	m, ok := featureMemories.features[uri]
+	if !ok {
+		m = newScopedMemory()
+		featureMemories.features[uri] = m
+	}
+	return m
+}
+
+// seedMemory fills scenario memory from defaults, suite and feature memory,
+// narrower scopes overriding wider ones.
+func (a *apiFeature) seedMemory() {
+	a.memory = map[string]interface{}{}
+	a.memoryScopes = map[string]string{}
+	for k, v := range defaultMemory {
+		a.memory[k] = v
+		a.memoryScopes[k] = scopeDefault
+	}
+	suiteMemory.copyTo(a.memory, a.memoryScopes, scopeSuite)
+	featureMemory(a.feature).copyTo(a.memory, a.memoryScopes, scopeFeature)
+}
+
+func (a *apiFeature) memoryScope(key string) string {
+	if scope, ok := a.memoryScopes[key]; ok {
+		return scope
+	}
+	return scopeScenario
+}
+
+func (a *apiFeature) iRememberGloballyAs(key, value string) error {
+	return a.rememberIn(suiteMemory, scopeSuite, key, a.getParsed(value))
+}
+func (a *apiFeature) iRememberGloballyAsBody(key string, value *godog.DocString) error {
+	return a.rememberIn(suiteMemory, scopeSuite, key, a.getParsed(value.Content))
+}
+func (a *apiFeature) iRememberForFeatureAs(key, value string) error {
+	return a.rememberIn(featureMemory(a.feature), scopeFeature, key, a.getParsed(value))
+}
+func (a *apiFeature) iRememberForFeatureAsBody(key string, value *godog.DocString) error {
+	return a.rememberIn(featureMemory(a.feature), scopeFeature, key, a.getParsed(value.Content))
+}
+
+func (a *apiFeature) rememberIn(m *scopedMemory, scope, key, value string) error {
+	m.set(key, value)
+	a.memory[key] = value
+	a.memoryScopes[key] = scope
+	log.Trace().Str("key", key).Str("val", value).Str("scope", scope).Msg("Remembered")
+	return nil
+}
+
+func (a *apiFeature) iUnsetGlobalMemory(key string) error {
+	log.Trace().Str("key", key).Str("scope", scopeSuite).Msg("Memory unset")
+	suiteMemory.unset(key)
+	return a.iUnsetMemory(key)
+}
+func (a *apiFeature) iUnsetFeatureMemory(key string) error {
+	log.Trace().Str("key", key).Str("scope", scopeFeature).Msg("Memory unset")
+	featureMemory(a.feature).unset(key)
+	return a.iUnsetMemory(key)
+}
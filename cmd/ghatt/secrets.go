@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	secretMask = "***"
+	// shorter values are not masked in free text, they would hide too much
+	minSecretLength = 4
+)
+
+var (
+	// keys of memory, headers and cookies holding secrets, lower case
+	secretKeys = map[string]bool{
+		"authorization":       true,
+		"proxy-authorization": true,
+		"cookie":              true,
+		"set-cookie":          true,
+	}
+	secretSuffixes = []string{"_SECRET", "_TOKEN"}
+	secretPatterns []*regexp.Regexp
+
+	// values seen under secret keys, longest first
+	secretValues = struct {
+		sync.RWMutex
+		values []string
+	}{}
+)
+
+// loadSecrets adds SECRET_KEYS and SECRET_PATTERNS (comma separated regular
+// expressions matched against any output) to the detected secrets.
+func loadSecrets() error {
+	for _, key := range splitList(os.Getenv("SECRET_KEYS")) {
+		secretKeys[strings.ToLower(key)] = true
+	}
+	// one pattern per line, as patterns may contain commas like [0-9]{2,4}
+	for _, pattern := range strings.Split(os.Getenv("SECRET_PATTERNS"), "\n") {
+		pattern = strings.TrimSuffix(pattern, "\r")
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Cannot parse secret pattern %s: %s", pattern, err)
+		}
+		secretPatterns = append(secretPatterns, re)
+	}
+	return nil
+}
+
+func isSecretKey(key string) bool {
+	if secretKeys[strings.ToLower(key)] {
+		return true
+	}
+	key = strings.ToUpper(key)
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// registerSecret remembers the value of a secret key so it is masked
+// wherever it shows up, with the credentials of "Bearer xyz" and the values
+// of cookies.
+func registerSecret(key, value string) {
+	values := []string{value}
+	switch strings.ToLower(key) {
+	case "cookie", "set-cookie":
+		for i, pair := range strings.Split(value, ";") {
+			if kv := strings.SplitN(strings.TrimSpace(pair), "=", 2); len(kv) == 2 {
+				values = append(values, kv[1])
+			}
+			if strings.ToLower(key) == "set-cookie" && i == 0 {
+				break
+			}
+		}
+	default:
+		if i := strings.Index(value, " "); i > 0 {
+			values = append(values, value[i+1:])
+		}
+	}
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	for _, v := range values {
+		if len(v) < minSecretLength || strings.Contains(v, secretMask) || containsString(secretValues.values, v) {
+			continue
+		}
+		secretValues.values = append(secretValues.values, v)
+	}
+	sort.Slice(secretValues.values, func(i, j int) bool {
+		return len(secretValues.values[i]) > len(secretValues.values[j])
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// registerSecrets collects secrets from memory, headers and response cookies.
+func (a *apiFeature) registerSecrets() {
+	for k, v := range a.memory {
+		if isSecretKey(k) {
+			registerSecret(k, fmt.Sprintf("%v", v))
+		}
+	}
+	for k, v := range a.headers {
+		if isSecretKey(k) {
+			registerSecret(k, v)
+		}
+	}
+	for _, c := range a.lastCookies {
+		registerSecret("cookie", c.Name+"="+c.Value)
+	}
+}
+
+// maskSecrets replaces known secret values and matches of the secret
+// patterns in text.
+func maskSecrets(text string) string {
+	secretValues.RLock()
+	for _, v := range secretValues.values {
+		text = strings.Replace(text, v, secretMask, -1)
+		// as escaped in JSON log lines
+		if escaped, _ := json.Marshal(v); string(escaped[1:len(escaped)-1]) != v {
+			text = strings.Replace(text, string(escaped[1:len(escaped)-1]), secretMask, -1)
+		}
+	}
+	secretValues.RUnlock()
+	for _, re := range secretPatterns {
+		text = re.ReplaceAllString(text, secretMask)
+	}
+	return text
+}
+
+// maskValue masks the value of a secret key, keeping the auth scheme like
+// "Bearer ***", other values only have known secrets masked.
+func maskValue(key, value string) string {
+	if !isSecretKey(key) {
+		return maskSecrets(value)
+	}
+	registerSecret(key, value)
+	if i := strings.Index(value, " "); i > 0 && !strings.Contains(value[:i], "=") {
+		return value[:i+1] + secretMask
+	}
+	return secretMask
+}
+
+// secretWriter masks secrets in log lines, remembering values logged under
+// secret keys, like {"key":"API_TOKEN","val":"..."}, first.
+type secretWriter struct {
+	out io.Writer
+}
+
+func (w secretWriter) Write(p []byte) (int, error) {
+	var fields map[string]interface{}
+	if json.Unmarshal(p, &fields) == nil {
+		for _, k := range []string{"key", "k", "name"} {
+			key, ok := fields[k].(string)
+			if !ok || !isSecretKey(key) {
+				continue
+			}
+			for _, v := range []string{"val", "v", "value"} {
+				if value, ok := fields[v].(string); ok {
+					registerSecret(key, value)
+				}
+			}
+		}
+	}
+	if _, err := w.out.Write([]byte(maskSecrets(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
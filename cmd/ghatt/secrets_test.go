@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoadSecretPatterns(t *testing.T) {
+	defer func(patterns string) {
+		os.Setenv("SECRET_PATTERNS", patterns)
+		secretPatterns = nil
+	}(os.Getenv("SECRET_PATTERNS"))
+	os.Setenv("SECRET_PATTERNS", "card=[0-9]{4,6}\r\n\n\"token\":\"[^\"]*\"\n")
+	secretPatterns = nil
+	if err := loadSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if len(secretPatterns) != 2 {
+		t.Fatalf("got %d patterns, want 2", len(secretPatterns))
+	}
+	got := maskSecrets(`card=12345 {"token":"abc","id":1}`)
+	if want := secretMask + ` {` + secretMask + `,"id":1}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// signingNow is the clock of the signers, replaced in tests.
+var signingNow = time.Now
+
+// requestSigner signs requests made by sendrequestTo after all headers are
+// set, so signatures cover the final templated body and headers.
+type requestSigner interface {
+	sign(req *http.Request, body []byte) error
+}
+
+type hmacSigner struct {
+	key    string
+	header string
+}
+
+// sign puts the hex encoded HMAC-SHA256 of the body into the header.
+func (s *hmacSigner) sign(req *http.Request, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(s.key))
+	mac.Write(body)
+	req.Header.Set(s.header, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+type awsSigV4Signer struct {
+	service      string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func (s *awsSigV4Signer) sign(req *http.Request, body []byte) error {
+	now := signingNow().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if s.service != "s3" {
+		path = awsEscape(path, false)
+	}
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, []byte(part))
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+	log.Trace().Str("canonical", canonicalRequest).Msg("AWS SigV4")
+	return nil
+}
+
+func awsCanonicalQuery(query url.Values) string {
+	var pairs []string
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape URI-encodes everything except unreserved characters (and "/"
+// unless encodeSlash is set).
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// httpMessageSigner implements HTTP Message Signatures (RFC 9421).
+type httpMessageSigner struct {
+	alg        string
+	key        string
+	keyID      string
+	components []string
+}
+
+func (s *httpMessageSigner) sign(req *http.Request, body []byte) error {
+	var lines, quoted []string
+	for _, c := range s.components {
+		if c == "content-digest" && req.Header.Get("Content-Digest") == "" {
+			sum := sha256.Sum256(body)
+			req.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		}
+		value, err := signatureComponent(req, c)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, strconv.Quote(c)+": "+value)
+		quoted = append(quoted, strconv.Quote(c))
+	}
+	params := fmt.Sprintf("(%s);created=%d;keyid=%s;alg=%s", strings.Join(quoted, " "), signingNow().Unix(), strconv.Quote(s.keyID), strconv.Quote(s.alg))
+	lines = append(lines, `"@signature-params": `+params)
+	base := strings.Join(lines, "\n")
+	signature, err := s.signature([]byte(base))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature-Input", "sig1="+params)
+	req.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	log.Trace().Str("base", base).Msg("HTTP message signature")
+	return nil
+}
+
+func signatureComponent(req *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return req.Method, nil
+	case "@target-uri":
+		return req.URL.String(), nil
+	case "@authority":
+		if req.Host != "" {
+			return strings.ToLower(req.Host), nil
+		}
+		return strings.ToLower(req.URL.Host), nil
+	case "@scheme":
+		return strings.ToLower(req.URL.Scheme), nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	case "@path":
+		return req.URL.EscapedPath(), nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("Unsupported signature component %s", name)
+	}
+	values, ok := req.Header[http.CanonicalHeaderKey(name)]
+	if !ok {
+		return "", fmt.Errorf("Cannot sign missing header %s", name)
+	}
+	var trimmed []string
+	for _, v := range values {
+		trimmed = append(trimmed, strings.TrimSpace(v))
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+func (s *httpMessageSigner) signature(base []byte) ([]byte, error) {
+	switch s.alg {
+	case "hmac-sha256":
+		return hmacSHA256([]byte(s.key), base), nil
+	case "rsa-v1_5-sha256":
+		return jwtSign("RS256", s.key, base)
+	case "ecdsa-p256-sha256":
+		return jwtSign("ES256", s.key, base)
+	case "rsa-pss-sha512":
+		k, err := parsePrivateKey(s.key)
+		if err != nil {
+			return nil, err
+		}
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a RSA private key", s.alg)
+		}
+		return rsa.SignPSS(rand.Reader, rk, crypto.SHA512, hashed(crypto.SHA512, base), &rsa.PSSOptions{SaltLength: 64})
+	case "ed25519":
+		k, err := parsePrivateKey(s.key)
+		if err != nil {
+			return nil, err
+		}
+		ek, ok := k.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an Ed25519 private key", s.alg)
+		}
+		return ed25519.Sign(ek, base), nil
+	}
+	return nil, fmt.Errorf("Unsupported signature algorithm %s", s.alg)
+}
+
+func (a *apiFeature) iSignRequestsWithAWSSigV4ForServiceRegion(service, region string) error {
+	signer := &awsSigV4Signer{
+		service:      a.getParsed(service),
+		region:       a.getParsed(region),
+		accessKey:    a.setting("AWS_ACCESS_KEY_ID"),
+		secretKey:    a.setting("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: a.setting("AWS_SESSION_TOKEN"),
+	}
+	if signer.accessKey == "" || signer.secretKey == "" {
+		return fmt.Errorf("No AWS credentials defined. Please set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env variables/memory.")
+	}
+	a.signer = signer
+	return nil
+}
+
+func (a *apiFeature) iSignRequestsWithHMACSHA256UsingKeyIntoHeader(key, header string) error {
+	a.signer = &hmacSigner{key: a.getParsed(key), header: header}
+	return nil
+}
+
+func (a *apiFeature) iSignRequestsWithHTTPMessageSignatures(alg, key, keyID, components string) error {
+	a.signer = &httpMessageSigner{
+		alg:        alg,
+		key:        a.getParsed(key),
+		keyID:      a.getParsed(keyID),
+		components: strings.Fields(components),
+	}
+	return nil
+}
+
+func (a *apiFeature) iDoNotSignRequests() error {
+	a.signer = nil
+	return nil
+}
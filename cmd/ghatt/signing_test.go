@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withSigningTime(t *testing.T, value string) {
+	now, err := time.Parse("20060102T150405Z", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingNow = func() time.Time { return now }
+	t.Cleanup(func() { signingNow = time.Now })
+}
+
+// TestAWSSigV4Signer uses vectors of the AWS SigV4 test suite and the IAM
+// example of the SigV4 documentation.
+func TestAWSSigV4Signer(t *testing.T) {
+	withSigningTime(t, "20150830T123600Z")
+	tests := []struct {
+		name          string
+		service       string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		authorization string
+	}{
+		{
+			"get-vanilla", "service", "GET", "https://example.amazonaws.com/", "", "",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			"post-vanilla", "service", "POST", "https://example.amazonaws.com/", "", "",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			"get-vanilla-query-order-key-case", "service", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "", "",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			"post-x-www-form-urlencoded", "service", "POST", "https://example.amazonaws.com/", "application/x-www-form-urlencoded", "Param1=value1",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			"iam list users", "iam", "GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", "application/x-www-form-urlencoded; charset=utf-8", "",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			signer := &awsSigV4Signer{service: tt.service, region: "us-east-1", accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+			if err := signer.sign(req, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.authorization {
+				t.Errorf("got Authorization\n%s\nwant\n%s", got, tt.authorization)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("got X-Amz-Date %s, want 20150830T123600Z", got)
+			}
+		})
+	}
+}
+
+func TestAWSSigV4SignerS3PayloadHash(t *testing.T) {
+	withSigningTime(t, "20150830T123600Z")
+	req, err := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/a%20b.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &awsSigV4Signer{service: "s3", region: "us-east-1", accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", sessionToken: "token"}
+	if err := signer.sign(req, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Header.Get("X-Amz-Content-Sha256"), sha256Hex([]byte("hello")); got != want {
+		t.Errorf("got X-Amz-Content-Sha256 %s, want %s", got, want)
+	}
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Errorf("got Authorization %s, want the payload hash and session token signed", got)
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		body      string
+		signature string
+	}{
+		{"empty", "", "", "b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+		{"wikipedia", "key", "The quick brown fox jumps over the lazy dog", "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer := &hmacSigner{key: tt.key, header: "X-Signature"}
+			if err := signer.sign(req, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("X-Signature"); got != tt.signature {
+				t.Errorf("got %s, want %s", got, tt.signature)
+			}
+		})
+	}
+}
+
+// TestHTTPMessageSigner signs the request of the RFC 9421 examples, with the
+// alg parameter the signer always adds.
+func TestHTTPMessageSigner(t *testing.T) {
+	withSigningTime(t, "20210420T020753Z")
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("POST", "https://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+	body := []byte(`{"hello": "world"}`)
+
+	t.Run("hmac-sha256", func(t *testing.T) {
+		req := newRequest()
+		signer := &httpMessageSigner{alg: "hmac-sha256", key: "secret", keyID: "test-shared-secret", components: []string{"date", "@authority", "content-type"}}
+		if err := signer.sign(req, body); err != nil {
+			t.Fatal(err)
+		}
+		params := `("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret";alg="hmac-sha256"`
+		if got := req.Header.Get("Signature-Input"); got != "sig1="+params {
+			t.Errorf("got Signature-Input %s, want sig1=%s", got, params)
+		}
+		base := "\"date\": Tue, 20 Apr 2021 02:07:55 GMT\n\"@authority\": example.com\n\"content-type\": application/json\n\"@signature-params\": " + params
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(base))
+		if got, want := req.Header.Get("Signature"), "sig1=:"+base64.StdEncoding.EncodeToString(mac.Sum(nil))+":"; got != want {
+			t.Errorf("got Signature %s, want %s", got, want)
+		}
+	})
+
+	t.Run("ed25519 with content digest", func(t *testing.T) {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := newRequest()
+		signer := &httpMessageSigner{
+			alg:        "ed25519",
+			key:        string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+			keyID:      "test-key-ed25519",
+			components: []string{"@method", "@path", "@query", "content-digest"},
+		}
+		if err := signer.sign(req, body); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := req.Header.Get("Content-Digest"), "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"; got != want {
+			t.Errorf("got Content-Digest %s, want %s", got, want)
+		}
+		params := `("@method" "@path" "@query" "content-digest");created=1618884473;keyid="test-key-ed25519";alg="ed25519"`
+		base := "\"@method\": POST\n\"@path\": /foo\n\"@query\": ?param=Value&Pet=dog\n\"content-digest\": sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:\n\"@signature-params\": " + params
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(req.Header.Get("Signature"), "sig1=:"), ":"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ed25519.Verify(key.Public().(ed25519.PublicKey), []byte(base), signature) {
+			t.Errorf("signature does not verify over\n%s", base)
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cucumber/godog"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite"
+)
+
+var databases = struct {
+	sync.Mutex
+	conns map[string]*sql.DB
+}{conns: map[string]*sql.DB{}}
+
+type database struct {
+	*sql.DB
+	driver string
+}
+
+// getDatabase opens (or reuses) the connection configured by DB_<NAME>_DSN,
+// with the driver taken from DB_<NAME>_DRIVER or the DSN scheme.
+func (a *apiFeature) getDatabase(name string) (*database, error) {
+	prefix := "DB_" + strings.ToUpper(name) + "_"
+	dsn := a.setting(prefix + "DSN")
+	if dsn == "" {
+		return nil, fmt.Errorf("No database %s defined. Please set %sDSN env variable/memory.", name, prefix)
+	}
+	driver, dsn := sqlDriver(a.setting(prefix+"DRIVER"), dsn)
+	key := driver + " " + dsn
+	databases.Lock()
+	defer databases.Unlock()
+	db, ok := databases.conns[key]
+	if !ok {
+		var err error
+		if db, err = sql.Open(driver, dsn); err != nil {
+			return nil, err
+		}
+		log.Debug().Str("name", name).Str("driver", driver).Msg("Opened database")
+		databases.conns[key] = db
+	}
+	return &database{DB: db, driver: driver}, nil
+}
+
+// setting reads a configuration value from memory, falling back to env.
+func (a *apiFeature) setting(key string) string {
+	if v, ok := a.memory[key]; ok {
+		return fmt.Sprintf("%v", v)
+	}
+	return os.Getenv(key)
+}
+
+func sqlDriver(driver, dsn string) (string, string) {
+	if driver != "" {
+		return driver, dsn
+	}
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return "postgres", dsn
+	case strings.HasPrefix(dsn, "mysql://"):
+		return "mysql", strings.TrimPrefix(dsn, "mysql://")
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return "sqlite", strings.TrimPrefix(dsn, "sqlite://")
+	default:
+		return "sqlite", dsn
+	}
+}
+
+func closeDatabases() {
+	databases.Lock()
+	defer databases.Unlock()
+	for key, db := range databases.conns {
+		db.Close()
+		delete(databases.conns, key)
+	}
+}
+
+func (db *database) placeholder(i int) string {
+	if db.driver == "postgres" {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// quoteIdentifier quotes a table or column name, so reserved words and mixed
+// case names work. Dots separate schema and table names.
+func (db *database) quoteIdentifier(name string) string {
+	quote := `"`
+	if db.driver == "mysql" {
+		quote = "`"
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.Replace(part, quote, quote+quote, -1) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// queryRows runs a query returning column names and rows as strings.
+func (db *database) queryRows(query string) ([]string, [][]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var result [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = sqlString(v)
+		}
+		result = append(result, row)
+	}
+	return columns, result, rows.Err()
+}
+
+func sqlString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (a *apiFeature) theDatabaseContainsRowsInTable(name, table string, rows *godog.Table) error {
+	db, err := a.getDatabase(name)
+	if err != nil {
+		return err
+	}
+	if len(rows.Rows) < 2 {
+		return fmt.Errorf("Expected a header row and at least one data row")
+	}
+	var columns, placeholders []string
+	for i, cell := range rows.Rows[0].Cells {
+		columns = append(columns, db.quoteIdentifier(cell.Value))
+		placeholders = append(placeholders, db.placeholder(i+1))
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", db.quoteIdentifier(table), strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	for _, row := range rows.Rows[1:] {
+		var values []interface{}
+		for _, cell := range row.Cells {
+			if cell.Value == "NULL" {
+				values = append(values, nil)
+			} else {
+				values = append(values, a.getParsed(cell.Value))
+			}
+		}
+		log.Trace().Str("query", query).Interface("values", values).Msg("SQL insert")
+		if _, err := db.Exec(query, values...); err != nil {
+			return fmt.Errorf("Cannot insert into %s: %s", table, err)
+		}
+	}
+	return nil
+}
+
+func (a *apiFeature) iExecuteSQLOn(name string, body *godog.DocString) error {
+	db, err := a.getDatabase(name)
+	if err != nil {
+		return err
+	}
+	query := a.getParsed(body.Content)
+	log.Trace().Str("query", query).Msg("SQL exec")
+	_, err = db.Exec(query)
+	return err
+}
+
+func (a *apiFeature) theQueryOnShouldReturn(query, name, value string) error {
+	db, err := a.getDatabase(name)
+	if err != nil {
+		return err
+	}
+	query = a.getParsed(query)
+	value = a.getParsed(value)
+	_, rows, err := db.queryRows(query)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return fmt.Errorf("No rows returned, expected=[%s] for query=[%s]", value, query)
+	}
+	if rows[0][0] != value {
+		return fmt.Errorf("No match for value, expected=[%s] got=[%s] for query=[%s]", value, rows[0][0], query)
+	}
+	return nil
+}
+
+func (a *apiFeature) theQueryOnShouldReturnRows(query, name string, expected *godog.Table) error {
+	db, err := a.getDatabase(name)
+	if err != nil {
+		return err
+	}
+	query = a.getParsed(query)
+	columns, rows, err := db.queryRows(query)
+	if err != nil {
+		return err
+	}
+	index := map[string]int{}
+	for i, c := range columns {
+		index[c] = i
+	}
+	if len(expected.Rows)-1 != len(rows) {
+		return fmt.Errorf("Expected %d rows, got %d rows=%v for query=[%s]", len(expected.Rows)-1, len(rows), rows, query)
+	}
+	header := expected.Rows[0].Cells
+	for r, row := range expected.Rows[1:] {
+		for c, cell := range row.Cells {
+			i, ok := index[header[c].Value]
+			if !ok {
+				return fmt.Errorf("No column %s returned by query=[%s]", header[c].Value, query)
+			}
+			if value := a.getParsed(cell.Value); rows[r][i] != value {
+				return fmt.Errorf("No match for row %d column %s, expected=[%s] got=[%s] for query=[%s]", r+1, header[c].Value, value, rows[r][i], query)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *apiFeature) iRememberSQLQueryOnAs(query, name, key string) error {
+	db, err := a.getDatabase(name)
+	if err != nil {
+		return err
+	}
+	query = a.getParsed(query)
+	_, rows, err := db.queryRows(query)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return fmt.Errorf("No rows returned for query=[%s]", query)
+	}
+	a.memory[key] = rows[0][0]
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", rows[0][0]).Msg("Remembered")
+	return nil
+}
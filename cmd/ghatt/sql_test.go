@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cucumber/godog"
+	"github.com/cucumber/messages-go/v16"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		driver string
+		name   string
+		want   string
+	}{
+		{"sqlite", "order", `"order"`},
+		{"postgres", "public.userAccounts", `"public"."userAccounts"`},
+		{"postgres", `odd"name`, `"odd""name"`},
+		{"mysql", "Group", "`Group`"},
+		{"mysql", "odd`name", "`odd``name`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver+" "+tt.name, func(t *testing.T) {
+			db := &database{driver: tt.driver}
+			if got := db.quoteIdentifier(tt.name); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func sqlTable(rows ...[]string) *godog.Table {
+	table := &godog.Table{}
+	for _, cells := range rows {
+		row := &messages.PickleTableRow{}
+		for _, c := range cells {
+			row.Cells = append(row.Cells, &messages.PickleTableCell{Value: c})
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+func TestSQLSteps(t *testing.T) {
+	defer closeDatabases()
+	a := &apiFeature{memory: map[string]interface{}{
+		"DB_MAIN_DSN": "sqlite://file:ghatt_sql_test?mode=memory&cache=shared",
+		"USER":        "u1",
+	}}
+	if err := a.iExecuteSQLOn("main", &godog.DocString{Content: `CREATE TABLE "order" ("Group" TEXT, "userId" TEXT, note TEXT)`}); err != nil {
+		t.Fatal(err)
+	}
+	seed := sqlTable(
+		[]string{"Group", "userId", "note"},
+		[]string{"a", "{{.USER}}", "NULL"},
+		[]string{"b", "u2", "x"},
+	)
+	if err := a.theDatabaseContainsRowsInTable("main", "order", seed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.theQueryOnShouldReturn(`SELECT count(*) FROM "order"`, "main", "2"); err != nil {
+		t.Error(err)
+	}
+	if err := a.theQueryOnShouldReturn(`SELECT count(*) FROM "order"`, "main", "3"); err == nil {
+		t.Error("got a count of 3 matching, want an error")
+	}
+	expected := sqlTable(
+		[]string{"userId", "Group", "note"},
+		[]string{"{{.USER}}", "a", "NULL"},
+		[]string{"u2", "b", "x"},
+	)
+	if err := a.theQueryOnShouldReturnRows(`SELECT * FROM "order" ORDER BY "Group"`, "main", expected); err != nil {
+		t.Error(err)
+	}
+	mismatch := sqlTable([]string{"Group"}, []string{"b"}, []string{"a"})
+	if err := a.theQueryOnShouldReturnRows(`SELECT "Group" FROM "order" ORDER BY "Group"`, "main", mismatch); err == nil {
+		t.Error("got rows in the wrong order matching, want an error")
+	}
+	if err := a.theQueryOnShouldReturnRows(`SELECT "Group" FROM "order" WHERE "userId" = 'u1'`, "main", mismatch); err == nil {
+		t.Error("got a different row count matching, want an error")
+	}
+	if err := a.theDatabaseContainsRowsInTable("main", "missing", seed); err == nil {
+		t.Error("got rows inserted into a missing table, want an error")
+	}
+}
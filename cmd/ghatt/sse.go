@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// eventStream is a Server-Sent Events response read in the background, so
+// other steps can run while the stream is open.
+type eventStream struct {
+	*messageQueue
+	cancel context.CancelFunc
+}
+
+func (a *apiFeature) iOpenEventStream(method, path string) error {
+	url, err := a.requestURL(path)
+	if err != nil {
+		return err
+	}
+	a.closeEventStream()
+	req, err := a.newRequest(method, url, "")
+	if err != nil {
+		return err
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	client, err := a.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		cancel()
+		return fmt.Errorf("Cannot open event stream %s: code=%d status=%s", url, resp.StatusCode, resp.Status)
+	}
+	stream := &eventStream{messageQueue: newMessageQueue("event stream " + url), cancel: cancel}
+	a.eventStream = stream
+	log.Trace().Str("url", url).Msg("Event stream opened")
+	go func() {
+		defer resp.Body.Close()
+		err := readEvents(bufio.NewReader(resp.Body), func(e sseEvent) {
+			log.Trace().Str("event", e.event).Str("id", e.id).Msg(e.data)
+			stream.add(e)
+		})
+		stream.finish(err)
+		log.Trace().Str("url", url).Err(err).Msg("Event stream ended")
+	}()
+	return nil
+}
+
+// readEvents parses the text/event-stream format until the body ends.
+func readEvents(r *bufio.Reader, emit func(sseEvent)) error {
+	var e sseEvent
+	var data []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				e.data = strings.Join(data, "\n")
+				if e.event == "" {
+					e.event = "message"
+				}
+				emit(e)
+			}
+			e, data = sseEvent{id: e.id}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			e.event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			e.id = value
+		}
+	}
+}
+
+func (a *apiFeature) closeEventStream() {
+	if a.eventStream == nil {
+		return
+	}
+	a.eventStream.finish(nil)
+	a.eventStream.cancel()
+	a.eventStream = nil
+}
+
+func (a *apiFeature) iCloseEventStream() error {
+	if a.eventStream == nil {
+		return fmt.Errorf("No event stream open")
+	}
+	a.closeEventStream()
+	return nil
+}
+
+func (a *apiFeature) waitForEvent(timeout string, match func(sseEvent) bool) (sseEvent, error) {
+	if a.eventStream == nil {
+		return sseEvent{}, fmt.Errorf("No event stream open")
+	}
+	m, err := a.eventStream.wait(timeout, func(m interface{}) bool {
+		return match(m.(sseEvent))
+	})
+	if err != nil {
+		return sseEvent{}, err
+	}
+	return m.(sseEvent), nil
+}
+
+func (a *apiFeature) withinIShouldReceiveAnEvent(timeout, name string) error {
+	name = a.getParsed(name)
+	_, err := a.waitForEvent(timeout, func(e sseEvent) bool {
+		return e.event == name
+	})
+	return err
+}
+
+func (a *apiFeature) withinIShouldReceiveAnEventWhoseDataJqMatches(timeout, name, path, value string) error {
+	name, path, value = a.getParsed(name), a.getParsed(path), a.getParsed(value)
+	_, err := a.waitForEvent(timeout, func(e sseEvent) bool {
+		if e.event != name {
+			return false
+		}
+		actual, err := jqString([]byte(e.data), path)
+		return err == nil && actual == value
+	})
+	if err != nil {
+		return fmt.Errorf("%s, expected event=[%s] with path=[%s] value=[%s]", err, name, path, value)
+	}
+	return nil
+}
+
+func (a *apiFeature) withinIShouldReceiveAnEventAndRememberDataJqAs(timeout, name, path, key string) error {
+	name, path = a.getParsed(name), a.getParsed(path)
+	e, err := a.waitForEvent(timeout, func(e sseEvent) bool {
+		return e.event == name
+	})
+	if err != nil {
+		return err
+	}
+	value, err := jqString([]byte(e.data), path)
+	if err != nil {
+		return err
+	}
+	a.memory[key] = value
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", value).Msg("Remembered")
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cucumber/godog"
+)
+
+func TestReadEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []sseEvent
+	}{
+		{"single", "data: hello\n\n", []sseEvent{{event: "message", data: "hello"}}},
+		{"named with id", "id: 1\nevent: update\ndata: {\"a\":1}\n\n", []sseEvent{{id: "1", event: "update", data: `{"a":1}`}}},
+		{"multi-line data", "data: first\ndata:second\ndata:  indented\n\n", []sseEvent{{event: "message", data: "first\nsecond\n indented"}}},
+		{"empty data line", "data\ndata: x\n\n", []sseEvent{{event: "message", data: "\nx"}}},
+		{"comments and unknown fields", ": ping\nretry: 1000\ndata: x\n\n", []sseEvent{{event: "message", data: "x"}}},
+		{"crlf", "event: a\r\ndata: x\r\n\r\n", []sseEvent{{event: "a", data: "x"}}},
+		{"id kept for next events", "id: 7\ndata: a\n\ndata: b\n\n", []sseEvent{{id: "7", event: "message", data: "a"}, {id: "7", event: "message", data: "b"}}},
+		{"event without data skipped", "event: ping\n\ndata: x\n\n", []sseEvent{{event: "message", data: "x"}}},
+		{"unterminated event dropped", "data: a\n\ndata: b\n", []sseEvent{{event: "message", data: "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []sseEvent
+			err := readEvents(bufio.NewReader(strings.NewReader(tt.stream)), func(e sseEvent) {
+				got = append(got, e)
+			})
+			if err != io.EOF {
+				t.Errorf("got error %v, want EOF", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloseScenarioClosesEventStream(t *testing.T) {
+	closed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(closed)
+	}))
+	defer srv.Close()
+	api := &apiFeature{
+		memory:       map[string]interface{}{"HTTP_ENDPOINT": srv.URL},
+		headers:      map[string]string{},
+		variables:    map[string]interface{}{},
+		maxRedirects: -1,
+	}
+	if err := api.iOpenEventStream("GET", "/events"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.withinIShouldReceiveAnEvent("5s", "message"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.closeScenario(context.Background(), &godog.Scenario{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if api.eventStream != nil {
+		t.Error("got an open event stream after the scenario")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("got the request still open after the scenario")
+	}
+}
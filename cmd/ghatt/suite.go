@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cucumber/godog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	setupFeatures    string
+	teardownFeatures string
+	setupPaths       []string
+	teardownPaths    []string
+	mainPaths        []string
+	taggedSetup      bool
+	taggedTeardown   bool
+	teardownFailed   bool
+
+	// stopErr fails the scenarios left after a failed setup or an aborting
+	// reset, stopOutcome is printed instead of the suite result
+	stopErr     error
+	stopOutcome string
+	stopMutex   sync.Mutex
+
+	// pathLine matches the line suffix of a path like file.feature:12,
+	// as godog parses it
+	pathLine = regexp.MustCompile(`:\d+$`)
+)
+
+// prepareSuiteFeatures collects the setup and teardown features, both given
+// with --setup/--teardown and tagged @setup/@teardown in the suite paths,
+// and returns the suite paths without the --setup/--teardown features.
+func prepareSuiteFeatures(paths []string) (_ []string, err error) {
+	if setupPaths, err = expandDatasets(splitList(setupFeatures)); err != nil {
+		return nil, err
+	}
+	if teardownPaths, err = expandDatasets(splitList(teardownFeatures)); err != nil {
+		return nil, err
+	}
+	excluded := map[string]bool{}
+	for _, path := range append(setupPaths, teardownPaths...) {
+		excluded[filepath.Clean(path)] = true
+	}
+	mainPaths = nil
+	for _, path := range paths {
+		if !excluded[filepath.Clean(path)] {
+			mainPaths = append(mainPaths, path)
+		}
+	}
+	if taggedSetup, err = featuresContainTag(mainPaths, "@setup"); err != nil {
+		return nil, err
+	}
+	if taggedTeardown, err = featuresContainTag(mainPaths, "@teardown"); err != nil {
+		return nil, err
+	}
+	return mainPaths, nil
+}
+
+// stopSuite fails the remaining scenarios of the run on their first step,
+// godog hooks cannot stop the run by themselves.
+func stopSuite(err error, outcome string) {
+	stopMutex.Lock()
+	defer stopMutex.Unlock()
+	if stopOutcome == "" {
+		stopErr = err
+		stopOutcome = outcome
+	}
+}
+
+func suiteStopped() error {
+	stopMutex.Lock()
+	defer stopMutex.Unlock()
+	return stopErr
+}
+
+// excludeSuiteTags keeps @setup and @teardown scenarios out of the main run.
+func excludeSuiteTags(o *godog.Options) {
+	exclude := "~@setup && ~@teardown"
+	if o.Tags == "" {
+		o.Tags = exclude
+	} else {
+		o.Tags += " && " + exclude
+	}
+}
+
+func InitializeTestSuite(ctx *godog.TestSuiteContext) {
+	ctx.BeforeSuite(func() {
+		if status := runSuitePhase("setup", setupPaths, taggedSetup); status != 0 {
+			log.Error().Int("status", status).Msg("Suite setup failed")
+			stopSuite(fmt.Errorf("Suite setup failed"), "GHATT SETUP FAIL")
+		}
+	})
+	ctx.AfterSuite(func() {
+		// teardown runs even when the suite was stopped
+		stopMutex.Lock()
+		stopErr = nil
+		stopMutex.Unlock()
+		if status := runSuitePhase("teardown", teardownPaths, taggedTeardown); status != 0 {
+			log.Error().Int("status", status).Msg("Suite teardown failed")
+			teardownFailed = true
+		}
+		closeDatabases()
+	})
+}
+
+func runSuitePhase(name string, paths []string, tagged bool) int {
+	status := 0
+	if len(paths) > 0 {
+		status = runFeatures(name, paths, "")
+	}
+	if status == 0 && tagged {
+		status = runFeatures(name, mainPaths, "@"+name)
+	}
+	return status
+}
+
+// runFeatures runs features in a separate godog suite sharing the suite
+// memory, so values remembered globally are visible to the main run.
+func runFeatures(name string, paths []string, tags string) int {
+	o := opt
+	o.Paths = paths
+	o.Tags = tags
+	log.Debug().Str("phase", name).Strs("paths", paths).Str("tags", tags).Msg("Running suite phase")
+	return godog.TestSuite{
+		Name:                name,
+		ScenarioInitializer: InitializeScenario,
+		Options:             &o,
+	}.Run()
+}
+
+func featuresContainTag(paths []string, tag string) (bool, error) {
+	for _, path := range paths {
+		path = pathLine.ReplaceAllString(path, "")
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "@") {
+				continue
+			}
+			for _, t := range strings.Fields(line) {
+				if t == tag {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
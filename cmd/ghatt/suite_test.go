@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeaturesContainTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghatt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "suite.feature")
+	feature := "Feature: Suite\n\n  @setup @slow\n  Scenario: Seed\n    Given I remember \"A\" as \"1\"\n"
+	if err := ioutil.WriteFile(path, []byte(feature), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		paths []string
+		tag   string
+		want  bool
+	}{
+		{"file", []string{path}, "@setup", true},
+		{"file with line", []string{path + ":4"}, "@setup", true},
+		{"other tag", []string{path + ":4"}, "@teardown", false},
+		{"tag prefix", []string{path}, "@set", false},
+		{"missing file", []string{filepath.Join(dir, "missing.feature")}, "@setup", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := featuresContainTag(tt.paths, tt.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
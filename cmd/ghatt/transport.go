@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transportOptions are read from memory (falling back to env) for every
+// request, so they can be configured per environment and per scenario.
+type transportOptions struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ServerName string
+	Insecure   bool
+	Proxy      string // proxy URL, "direct" disables proxies from env
+	UnixSocket string
+	Resolve    string // comma separated host:port:address like curl --resolve
+}
+
+var transports = struct {
+	sync.Mutex
+	cache map[transportOptions]*http.Transport
+}{cache: map[transportOptions]*http.Transport{}}
+
+func (a *apiFeature) transportOptions() transportOptions {
+	insecure, _ := strconv.ParseBool(a.setting("TLS_INSECURE"))
+	return transportOptions{
+		CertFile:   a.setting("TLS_CERT_FILE"),
+		KeyFile:    a.setting("TLS_KEY_FILE"),
+		CAFile:     a.setting("TLS_CA_FILE"),
+		ServerName: a.setting("TLS_SERVER_NAME"),
+		Insecure:   insecure,
+		Proxy:      a.setting("PROXY_URL"),
+		UnixSocket: a.setting("UNIX_SOCKET"),
+		Resolve:    a.setting("RESOLVE"),
+	}
+}
+
+// transport returns a transport for the options, reusing connections of
+// requests made with the same options.
+func (o transportOptions) transport() (*http.Transport, error) {
+	transports.Lock()
+	defer transports.Unlock()
+	if t, ok := transports.cache[o]; ok {
+		return t, nil
+	}
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	switch o.Proxy {
+	case "":
+	case "direct":
+		t.Proxy = nil
+	default:
+		proxy, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse proxy URL %s: %s", o.Proxy, err)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if o.UnixSocket != "" || o.Resolve != "" {
+		resolve, err := o.resolveMap()
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if o.UnixSocket != "" {
+				return dialer.DialContext(ctx, "unix", o.UnixSocket)
+			}
+			if target, ok := resolve[addr]; ok {
+				addr = target
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	transports.cache[o] = t
+	return t, nil
+}
+
+// resolveMap maps "host:port" to "address:port" for every resolve entry.
+func (o transportOptions) resolveMap() (map[string]string, error) {
+	resolve := map[string]string{}
+	for _, entry := range splitList(o.Resolve) {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("Cannot parse resolve entry %s, expected host:port:address", entry)
+		}
+		address := strings.Trim(parts[2], "[]")
+		resolve[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(address, parts[1])
+	}
+	return resolve, nil
+}
+
+func (o transportOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.Insecure,
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func (a *apiFeature) client() (*http.Client, error) {
+	t, err := a.transportOptions().transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Jar: cookieJar, Transport: t, CheckRedirect: a.checkRedirect}, nil
+}
+
+func (a *apiFeature) iUseClientCertificateWithKey(cert, key string) error {
+	a.memory["TLS_CERT_FILE"] = a.getParsed(cert)
+	a.memory["TLS_KEY_FILE"] = a.getParsed(key)
+	return nil
+}
+func (a *apiFeature) iTrustCACertificatesFrom(file string) error {
+	a.memory["TLS_CA_FILE"] = a.getParsed(file)
+	return nil
+}
+func (a *apiFeature) iSetTLSServerNameTo(name string) error {
+	a.memory["TLS_SERVER_NAME"] = a.getParsed(name)
+	return nil
+}
+func (a *apiFeature) iSkipTLSVerification() error {
+	a.memory["TLS_INSECURE"] = "true"
+	return nil
+}
+
+func (a *apiFeature) iUseProxy(proxy string) error {
+	a.memory["PROXY_URL"] = a.getParsed(proxy)
+	return nil
+}
+func (a *apiFeature) iDoNotUseProxy() error {
+	a.memory["PROXY_URL"] = "direct"
+	return nil
+}
+func (a *apiFeature) iConnectToUnixSocket(path string) error {
+	a.memory["UNIX_SOCKET"] = a.getParsed(path)
+	return nil
+}
+func (a *apiFeature) iResolveTo(hostPort, address string) error {
+	entry := a.getParsed(hostPort) + ":" + a.getParsed(address)
+	if resolve := a.setting("RESOLVE"); resolve != "" {
+		entry = resolve + "," + entry
+	}
+	if _, err := (transportOptions{Resolve: entry}).resolveMap(); err != nil {
+		return err
+	}
+	a.memory["RESOLVE"] = entry
+	return nil
+}
+
+func (a *apiFeature) serverCertificate() (*x509.Certificate, error) {
+	if a.lastTLS == nil || len(a.lastTLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("No server certificate, the last request was not made over TLS")
+	}
+	return a.lastTLS.PeerCertificates[0], nil
+}
+
+func (a *apiFeature) theServerCertificateShouldExpireAfter(duration string) error {
+	cert, err := a.serverCertificate()
+	if err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	if !cert.NotAfter.After(time.Now().Add(d)) {
+		return fmt.Errorf("Expected server certificate to expire after %s, but it expires at %s", duration, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (a *apiFeature) theServerCertificateShouldBeValidFor(host string) error {
+	cert, err := a.serverCertificate()
+	if err != nil {
+		return err
+	}
+	return cert.VerifyHostname(a.getParsed(host))
+}
+
+func (a *apiFeature) theServerCertificateIssuerShouldBe(issuer string) error {
+	cert, err := a.serverCertificate()
+	if err != nil {
+		return err
+	}
+	issuer = a.getParsed(issuer)
+	if cert.Issuer.String() != issuer {
+		return fmt.Errorf("No match for server certificate issuer, expected=[%s] got=[%s]", issuer, cert.Issuer.String())
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cucumber/godog"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// headers set by the websocket handshake itself
+var websocketHandshakeHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+// websocketConn reads messages in the background, so steps can wait for
+// them while sending others.
+type websocketConn struct {
+	*messageQueue
+	conn *websocket.Conn
+}
+
+// websocketURL resolves a path against HTTP_ENDPOINT like requests do,
+// switching http(s) to ws(s).
+func (a *apiFeature) websocketURL(path string) (string, error) {
+	if parsed := a.getParsed(path); strings.HasPrefix(parsed, "ws://") || strings.HasPrefix(parsed, "wss://") {
+		return parsed, nil
+	}
+	url, err := a.requestURL(path)
+	if err != nil {
+		return "", err
+	}
+	return "ws" + strings.TrimPrefix(url, "http"), nil
+}
+
+func (a *apiFeature) iConnectToWebsocket(path string) error {
+	return a.connectWebsocket(path, nil)
+}
+func (a *apiFeature) iConnectToWebsocketWithSubprotocols(path, subprotocols string) error {
+	return a.connectWebsocket(path, splitList(a.getParsed(subprotocols)))
+}
+
+// connectWebsocket dials with the current headers, cookies and transport
+// options.
+func (a *apiFeature) connectWebsocket(path string, subprotocols []string) error {
+	url, err := a.websocketURL(path)
+	if err != nil {
+		return err
+	}
+	a.closeWebsocket()
+	t, err := a.transportOptions().transport()
+	if err != nil {
+		return err
+	}
+	dialer := websocket.Dialer{
+		Proxy:            t.Proxy,
+		TLSClientConfig:  t.TLSClientConfig,
+		NetDialContext:   t.DialContext,
+		HandshakeTimeout: 45 * time.Second,
+		Jar:              cookieJar,
+		Subprotocols:     subprotocols,
+	}
+	header := http.Header{}
+	for k, v := range a.headers {
+		if !websocketHandshakeHeaders[http.CanonicalHeaderKey(k)] {
+			header.Add(k, v)
+		}
+	}
+	conn, resp, err := dialer.Dial(url, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("Cannot connect to websocket %s: %s code=%d status=%s", url, err, resp.StatusCode, resp.Status)
+		}
+		return fmt.Errorf("Cannot connect to websocket %s: %s", url, err)
+	}
+	ws := &websocketConn{messageQueue: newMessageQueue("websocket " + url), conn: conn}
+	a.websocket = ws
+	log.Trace().Str("url", url).Str("subprotocol", conn.Subprotocol()).Msg("Websocket connected")
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				ws.finish(err)
+				log.Trace().Str("url", url).Err(err).Msg("Websocket closed")
+				return
+			}
+			log.Trace().Str("url", url).Msg(string(data))
+			ws.add(string(data))
+		}
+	}()
+	return nil
+}
+
+func (a *apiFeature) closeWebsocket() {
+	if a.websocket == nil {
+		return
+	}
+	a.websocket.finish(nil)
+	a.websocket.conn.Close()
+	a.websocket = nil
+}
+
+func (a *apiFeature) currentWebsocket() (*websocketConn, error) {
+	if a.websocket == nil {
+		return nil, fmt.Errorf("No websocket connected")
+	}
+	return a.websocket, nil
+}
+
+func (a *apiFeature) theWebsocketSubprotocolShouldBe(subprotocol string) error {
+	ws, err := a.currentWebsocket()
+	if err != nil {
+		return err
+	}
+	subprotocol = a.getParsed(subprotocol)
+	if actual := ws.conn.Subprotocol(); actual != subprotocol {
+		return fmt.Errorf("expected websocket subprotocol to be: %s, but actual is: %s", subprotocol, actual)
+	}
+	return nil
+}
+
+func (a *apiFeature) iSendWebsocketMessage(message string) error {
+	return a.sendWebsocketMessage(a.getParsed(message))
+}
+func (a *apiFeature) iSendWebsocketMessageBody(body *godog.DocString) error {
+	return a.sendWebsocketMessage(a.getParsed(body.Content))
+}
+func (a *apiFeature) iSendWebsocketJSON(body *godog.DocString) error {
+	message := a.getParsed(body.Content)
+	if !json.Valid([]byte(message)) {
+		return fmt.Errorf("Invalid json message: %s", message)
+	}
+	return a.sendWebsocketMessage(message)
+}
+func (a *apiFeature) sendWebsocketMessage(message string) error {
+	ws, err := a.currentWebsocket()
+	if err != nil {
+		return err
+	}
+	log.Trace().Msg(message)
+	return ws.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
+
+func (a *apiFeature) waitForWebsocketMessage(timeout string, match func(string) bool) (string, error) {
+	ws, err := a.currentWebsocket()
+	if err != nil {
+		return "", err
+	}
+	m, err := ws.wait(timeout, func(m interface{}) bool {
+		return match(m.(string))
+	})
+	if err != nil {
+		return "", err
+	}
+	return m.(string), nil
+}
+
+func (a *apiFeature) withinIShouldReceiveAWebsocketMessage(timeout, message string) error {
+	message = a.getParsed(message)
+	_, err := a.waitForWebsocketMessage(timeout, func(m string) bool {
+		return m == message
+	})
+	if err != nil {
+		return fmt.Errorf("%s, expected message=[%s]", err, message)
+	}
+	return nil
+}
+
+func (a *apiFeature) withinIShouldReceiveAWebsocketMessageWhoseJqMatches(timeout, path, value string) error {
+	path, value = a.getParsed(path), a.getParsed(value)
+	_, err := a.waitForWebsocketMessage(timeout, func(m string) bool {
+		actual, err := jqString([]byte(m), path)
+		return err == nil && actual == value
+	})
+	if err != nil {
+		return fmt.Errorf("%s, expected path=[%s] value=[%s]", err, path, value)
+	}
+	return nil
+}
+
+func (a *apiFeature) withinIShouldReceiveAWebsocketMessageAndRememberJqAs(timeout, path, key string) error {
+	path = a.getParsed(path)
+	var value string
+	_, err := a.waitForWebsocketMessage(timeout, func(m string) bool {
+		actual, err := jqString([]byte(m), path)
+		if err != nil {
+			return false
+		}
+		value = actual
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	a.memory[key] = value
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", value).Msg("Remembered")
+	return nil
+}
+
+func (a *apiFeature) iCloseWebsocket() error {
+	return a.iCloseWebsocketWithCode(websocket.CloseNormalClosure)
+}
+
+// iCloseWebsocketWithCode sends a close frame and waits briefly for the
+// server to acknowledge it.
+func (a *apiFeature) iCloseWebsocketWithCode(code int) error {
+	ws, err := a.currentWebsocket()
+	if err != nil {
+		return err
+	}
+	err = ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(5*time.Second))
+	if err != nil {
+		return err
+	}
+	if _, err := ws.waitClosed("5s"); err != nil {
+		log.Debug().Err(err).Msg("Websocket close not acknowledged")
+	}
+	a.closeWebsocket()
+	return nil
+}
+
+func (a *apiFeature) withinTheWebsocketShouldBeClosedWithCode(timeout string, code int) error {
+	ws, err := a.currentWebsocket()
+	if err != nil {
+		return err
+	}
+	closeErr, err := ws.waitClosed(timeout)
+	if err != nil {
+		return err
+	}
+	ce, ok := closeErr.(*websocket.CloseError)
+	if !ok {
+		return fmt.Errorf("expected websocket to be closed with code %d, but it was closed with: %v", code, closeErr)
+	}
+	if ce.Code != code {
+		return fmt.Errorf("expected websocket to be closed with code %d, but actual is: %d %s", code, ce.Code, ce.Text)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cucumber/godog"
+	"github.com/gorilla/websocket"
+)
+
+func TestCloseScenarioClosesWebsocket(t *testing.T) {
+	closed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	api := &apiFeature{
+		memory:       map[string]interface{}{"HTTP_ENDPOINT": srv.URL},
+		headers:      map[string]string{},
+		variables:    map[string]interface{}{},
+		maxRedirects: -1,
+	}
+	if err := api.iConnectToWebsocket("/ws"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.websocket.wait("5s", func(interface{}) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.closeScenario(context.Background(), &godog.Scenario{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if api.websocket != nil {
+		t.Error("got an open websocket after the scenario")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("got the connection still open after the scenario")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/rs/zerolog/log"
+)
+
+// xpathValue evaluates the expression on the XML response. Node sets give the
+// text of the first node, so both "//order/@id" and "count(//item)" work.
+// Namespace prefixes are resolved with registered namespaces, falling back to
+// the prefixes used in the document (e.g. "//soap:Body").
+func (a *apiFeature) xpathValue(path string) (string, int, error) {
+	doc, err := xmlquery.Parse(bytes.NewReader(a.lastBody))
+	if err != nil {
+		return "", 0, fmt.Errorf("Cannot parse XML response: %s", err)
+	}
+	var expr *xpath.Expr
+	if len(a.xmlNamespaces) > 0 {
+		namespaces := documentNamespaces(doc)
+		for prefix, uri := range a.xmlNamespaces {
+			namespaces[prefix] = uri
+		}
+		expr, err = xpath.CompileWithNS(path, namespaces)
+	} else {
+		expr, err = xpath.Compile(path)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	switch v := expr.Evaluate(xmlquery.CreateXPathNavigator(doc)).(type) {
+	case *xpath.NodeIterator:
+		value, count := "", 0
+		for v.MoveNext() {
+			if count == 0 {
+				value = v.Current().Value()
+			}
+			count++
+		}
+		return value, count, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), 1, nil
+	default:
+		return fmt.Sprintf("%v", v), 1, nil
+	}
+}
+
+// documentNamespaces collects the xmlns:prefix declarations of the document,
+// as compiling with registered namespaces rejects any other prefix.
+func documentNamespaces(doc *xmlquery.Node) map[string]string {
+	namespaces := map[string]string{}
+	var walk func(n *xmlquery.Node)
+	walk = func(n *xmlquery.Node) {
+		for _, attr := range n.Attr {
+			if _, ok := namespaces[attr.Name.Local]; attr.Name.Space == "xmlns" && !ok {
+				namespaces[attr.Name.Local] = attr.Value
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return namespaces
+}
+
+func (a *apiFeature) iRegisterXMLNamespaceAs(prefix, uri string) error {
+	a.xmlNamespaces[prefix] = a.getParsed(uri)
+	return nil
+}
+
+func (a *apiFeature) theResponseXpathShouldMatch(path, value string) (err error) {
+	defer a.withCurl(&err)
+	value = a.getParsed(value)
+	actual, count, err := a.xpathValue(a.getParsed(path))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("No match for xpath, expected=[%s] got no nodes for path=[%s]", value, path)
+	}
+	if actual != value {
+		return fmt.Errorf("No match for value, expected=[%s] got=[%s] for path=[%s]", value, actual, path)
+	}
+	return nil
+}
+
+func (a *apiFeature) theResponseXpathShouldMatchNodes(path string, expected int) (err error) {
+	defer a.withCurl(&err)
+	_, count, err := a.xpathValue(a.getParsed(path))
+	if err != nil {
+		return err
+	}
+	if count != expected {
+		return fmt.Errorf("expected %d nodes for xpath=[%s], but found %d", expected, path, count)
+	}
+	return nil
+}
+
+func (a *apiFeature) iRememberResponseXpathAs(path, key string) error {
+	value, count, err := a.xpathValue(a.getParsed(path))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("No nodes for xpath=[%s]", path)
+	}
+	a.memory[key] = value
+	delete(a.memoryScopes, key)
+	log.Trace().Str("key", key).Str("val", value).Msg("Remembered")
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+const soapOrder = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <order xmlns="urn:orders" id="42">
+      <item>a</item>
+      <item>b</item>
+    </order>
+  </soap:Body>
+</soap:Envelope>`
+
+func TestXpathValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces map[string]string
+		path       string
+		value      string
+		count      int
+	}{
+		{"document prefix", nil, "//soap:Body/*/@id", "42", 1},
+		{"count", nil, "count(//soap:Body/*/*)", "2", 1},
+		{"registered and document prefix", map[string]string{"o": "urn:orders"}, "//soap:Body/o:order/@id", "42", 1},
+		{"registered prefix", map[string]string{"o": "urn:orders"}, "//o:order/o:item", "a", 2},
+		{"registered prefix overrides document", map[string]string{"soap": "urn:other"}, "//soap:Body", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &apiFeature{lastBody: []byte(soapOrder), xmlNamespaces: map[string]string{}}
+			for prefix, uri := range tt.namespaces {
+				a.xmlNamespaces[prefix] = uri
+			}
+			value, count, err := a.xpathValue(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.value || count != tt.count {
+				t.Errorf("got value=%q count=%d, want value=%q count=%d", value, count, tt.value, tt.count)
+			}
+		})
+	}
+}
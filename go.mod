@@ -5,23 +5,28 @@ go 1.14
 require (
 	github.com/PaesslerAG/gval v1.1.1 // indirect
 	github.com/PaesslerAG/jsonpath v0.1.1
-	github.com/cucumber/godog v0.11.0
-	github.com/fatih/color v1.9.0 // indirect
-	github.com/gofrs/uuid v4.0.0+incompatible // indirect
-	github.com/gogo/protobuf v1.3.2 // indirect
-	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
-	github.com/hashicorp/go-memdb v1.3.2 // indirect
-	github.com/hokaccha/go-prettyjson v0.0.0-20190818114111-108c894c2c0e // indirect
-	github.com/itchyny/astgen-go v0.0.0-20210521111535-120f8643907b // indirect
+	github.com/PuerkitoBio/goquery v1.6.1
+	github.com/antchfx/xmlquery v1.3.5
+	github.com/antchfx/xpath v1.2.4
+	github.com/cucumber/godog v0.12.6
+	github.com/cucumber/messages-go/v16 v16.0.1
+	github.com/getkin/kin-openapi v0.94.0
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/golang/protobuf v1.5.2
+	github.com/gorilla/websocket v1.4.2
 	github.com/itchyny/gojq v0.12.4
+	github.com/jhump/protoreflect v1.9.0
 	github.com/joho/godotenv v1.3.0
 	github.com/kjk/betterguid v0.0.0-20170621091430-c442874ba63a
-	github.com/mattn/go-colorable v0.1.7 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/lib/pq v1.10.2
 	github.com/nsf/jsondiff v0.0.0-20210303162244-6ea32392771e
 	github.com/oklog/ulid/v2 v2.0.2
 	github.com/rs/zerolog v1.23.0
 	github.com/segmentio/ksuid v1.0.4
-	github.com/sony/sonyflake v1.0.0
-	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/tidwall/pretty v1.2.0
+	github.com/vektah/gqlparser/v2 v2.2.0
+	google.golang.org/grpc v1.43.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.14.1
 )